@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Anki notes are added through the AnkiConnect add-on, which serves
+// Anki's collection on a local port while Anki is running.
+const (
+	defaultAnkiURL   = "http://127.0.0.1:8765"
+	defaultAnkiDeck  = "randpage"
+	defaultAnkiModel = "Basic"
+)
+
+// ankiExcerpt is about the most text to put on a card.
+const ankiExcerpt = 1500
+
+var ankiClient = &http.Client{Timeout: 10 * time.Second}
+
+// addAnkiNote adds a note for the page p opened to, with the page's text
+// on the front and where it's from on the back.
+func addAnkiNote(p pick) error {
+	path, cleanup, err := p.Doc.local()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	t, ok := documentFormat(p.Doc, path).(texter)
+	if !ok {
+		return fmt.Errorf("can't read the text of %s", p.Doc.name())
+	}
+	text, err := t.pageText(path, p.Page)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return errors.New("the page has no text")
+	}
+
+	source := fmt.Sprintf("%s, p. %d", html.EscapeString(docTitle(p.Doc)), p.Page)
+	if len(p.Doc.Authors) > 0 {
+		source = html.EscapeString(strings.Join(p.Doc.Authors, ", ")) + ", " + source
+	}
+	back := fmt.Sprintf(`%s<br><a href="%s">%s</a>`, source, html.EscapeString(docURL(p.Doc)), html.EscapeString(p.Doc.String()))
+
+	return ankiRequest("addNote", map[string]any{
+		"note": map[string]any{
+			"deckName":  ankiSetting(cfg.Anki.Deck, defaultAnkiDeck),
+			"modelName": ankiSetting(cfg.Anki.Model, defaultAnkiModel),
+			"fields": map[string]string{
+				"Front": html.EscapeString(excerpt(strings.Join(strings.Fields(text), " "), ankiExcerpt)),
+				"Back":  back,
+			},
+			"tags": []string{"randpage"},
+		},
+	})
+}
+
+// ankiRequest calls an AnkiConnect action.
+func ankiRequest(action string, params any) error {
+	body, err := json.Marshal(map[string]any{"action": action, "version": 6, "params": params})
+	if err != nil {
+		return err
+	}
+
+	resp, err := ankiClient.Post(ankiSetting(cfg.Anki.URL, defaultAnkiURL), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("AnkiConnect: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AnkiConnect: %s", resp.Status)
+	}
+
+	var result struct {
+		Error *string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("AnkiConnect: %w", err)
+	}
+	if result.Error != nil {
+		return fmt.Errorf("AnkiConnect: %s", *result.Error)
+	}
+	return nil
+}
+
+func ankiSetting(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// excerpt returns text cut to about n characters, at a space.
+func excerpt(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndexAny(cut, " \n"); i > n/2 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// An annotator is a format that can tell which pages have been marked up.
+type annotator interface {
+	// annotatedPages returns a function reporting whether each page of the
+	// document at path carries annotations.
+	annotatedPages(path string) (func(page int) bool, error)
+}
+
+// markupAnnots are the annotation subtypes that someone reading the
+// document adds: notes, highlights, and drawings, though not links or
+// form fields.
+var markupAnnots = map[string]bool{
+	"Text": true, "FreeText": true, "Line": true, "Square": true,
+	"Circle": true, "Polygon": true, "PolyLine": true, "Highlight": true,
+	"Underline": true, "Squiggly": true, "StrikeOut": true, "Caret": true,
+	"Ink": true, "Stamp": true,
+}
+
+// annotatedPages reports a page as annotated when it has any markup
+// annotations.
+func (pdfFormat) annotatedPages(path string) (func(page int) bool, error) {
+	doc, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+	xrt := doc.XRefTable
+
+	return func(page int) bool {
+		d, _, _, err := xrt.PageDict(page, false)
+		if err != nil || d == nil {
+			return false
+		}
+		o, ok := d.Find("Annots")
+		if !ok {
+			return false
+		}
+		annots, err := xrt.DereferenceArray(o)
+		if err != nil {
+			return false
+		}
+		for _, o := range annots {
+			annot, err := xrt.DereferenceDict(o)
+			if err != nil || annot == nil {
+				continue
+			}
+			if subtype := annot.NameEntry("Subtype"); subtype != nil && markupAnnots[*subtype] {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// looksLikeArchive reports whether a file might contain documents. Formats
+// that happen to be zip files themselves (epub, cbz) are documents, not
+// archives.
+func looksLikeArchive(name string) bool {
+	return isZip(name) || isTar(name)
+}
+
+func isZip(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".zip")
+}
+
+func isTar(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// archiveCandidates lists the documents inside an archive.
+func archiveCandidates(archive string) ([]candidate, error) {
+	if isTar(archive) {
+		return tarCandidates(archive)
+	}
+	return zipCandidates(archive)
+}
+
+// extractMember copies one document out of an archive into a temporary
+// directory, keeping its base name so the viewer's title is meaningful.
+func extractMember(archive, member string) (string, func(), error) {
+	r, err := openMember(archive, member)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+
+	return extractTemp(path.Base(member), r)
+}
+
+// openMember opens one document in an archive for reading. Closing it
+// closes the archive.
+func openMember(archive, member string) (io.ReadCloser, error) {
+	if isTar(archive) {
+		return openTarMember(archive, member)
+	}
+	return openZipMember(archive, member)
+}
+
+// memberReader reads an archive member, closing the archive with it.
+type memberReader struct {
+	io.Reader
+	archive io.Closer
+}
+
+func (r memberReader) Close() error {
+	return r.archive.Close()
+}
+
+// zipCandidates lists the documents inside a zip archive.
+func zipCandidates(archive string) ([]candidate, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var ret []candidate
+	for _, f := range zr.File {
+		if f.FileInfo().Mode().IsRegular() && looksLikeDocument(f.Name) {
+			ret = append(ret, candidate{Path: archive, Member: f.Name, Size: int64(f.UncompressedSize64), ModTime: f.Modified})
+		}
+	}
+
+	return ret, nil
+}
+
+func openZipMember(archive, member string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	// By the name zipCandidates listed it under: zr.Open won't take
+	// names that aren't valid fs paths, like "./a.pdf" or "/a.pdf".
+	for _, f := range zr.File {
+		if f.Name != member {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			zr.Close()
+			return nil, err
+		}
+
+		// Closing the zip file is enough; a member has nothing of its
+		// own to release.
+		return memberReader{r, zr}, nil
+	}
+
+	zr.Close()
+	return nil, fmt.Errorf("%s: no member %s", archive, member)
+}
+
+// openTar opens a tar archive, decompressing it if needed.
+func openTar(archive string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(archive), ".tar") {
+		return tar.NewReader(f), f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return tar.NewReader(gz), f, nil
+}
+
+func tarCandidates(archive string) ([]candidate, error) {
+	tr, closer, err := openTar(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var ret []candidate
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && looksLikeDocument(hdr.Name) {
+			ret = append(ret, candidate{Path: archive, Member: hdr.Name, Size: hdr.Size, ModTime: hdr.ModTime})
+		}
+	}
+
+	return ret, nil
+}
+
+// openTarMember reads through the archive to member. Tar files have no
+// index, so only the chosen document is extracted, at open time.
+func openTarMember(archive, member string) (io.ReadCloser, error) {
+	tr, closer, err := openTar(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			closer.Close()
+			return nil, fmt.Errorf("%s: no member %s", archive, member)
+		}
+		if err != nil {
+			closer.Close()
+			return nil, err
+		}
+
+		if hdr.Name == member {
+			return memberReader{tr, closer}, nil
+		}
+	}
+}
+
+// extractTemp writes r to a file called name in a new temporary directory.
+func extractTemp(name string, r io.Reader) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "randpage")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	dst, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		cleanup()
+		return "", nil, err
+	}
+
+	if err := dst.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return dst.Name(), cleanup, nil
+}
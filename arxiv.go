@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// arxivSource picks papers from arXiv, either from a reading list file of
+// arXiv ids (arxiv:~/papers.txt) or from the results of an API search
+// query (arxiv:?cat:cs.LG AND ti:attention). Papers are downloaded from
+// arxiv.org when picked, and cached like any other url. Titles and
+// authors for the reading list are looked up through the API once and
+// remembered in the cache directory.
+type arxivSource struct {
+	file  string
+	query string
+	w     *walker
+}
+
+const arxivAPI = "https://export.arxiv.org/api/query"
+
+// arxivBatch is how many ids are looked up per API request. arXiv asks
+// for a few seconds between requests, so big reading lists take a while
+// the first time.
+const arxivBatch = 100
+
+// arxivID matches a new-style (2101.00001) or old-style (hep-th/9901001)
+// arXiv id, with an optional version, anywhere in a line: a bare id,
+// arXiv:id, or an abs or pdf url.
+var arxivID = regexp.MustCompile(`(\d{4}\.\d{4,5}|[a-z-]+(?:\.[A-Z]{2})?/\d{7})(v\d+)?`)
+
+func newArxivSource(arg string, w *walker) (source, error) {
+	rest := strings.TrimPrefix(arg, "arxiv:")
+	if query, ok := strings.CutPrefix(rest, "?"); ok {
+		return arxivSource{query: query, w: w}, nil
+	}
+	if rest == "" {
+		return nil, errors.New("arxiv: give a reading list file or ?query")
+	}
+	return arxivSource{file: expandHome(rest), w: w}, nil
+}
+
+// An arxivPaper is what the API says about a paper.
+type arxivPaper struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Authors   []string  `json:"authors"`
+	Published time.Time `json:"published"`
+}
+
+func (p arxivPaper) candidate() candidate {
+	return candidate{
+		Path:    "https://arxiv.org/pdf/" + p.ID + ".pdf",
+		Title:   p.Title,
+		Authors: p.Authors,
+		ModTime: p.Published,
+	}
+}
+
+func (s arxivSource) list() ([]candidate, error) {
+	var papers []arxivPaper
+	var err error
+	if s.query != "" {
+		papers, err = arxivSearch(url.Values{"search_query": {s.query}, "max_results": {"1000"}})
+	} else {
+		papers, err = s.readingList()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []candidate
+	for _, p := range papers {
+		c := p.candidate()
+		if !s.w.skipRemote("", c.Path, false) {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+func (s arxivSource) fetch(c candidate, w io.Writer) error {
+	return httpSource{}.fetch(c, w)
+}
+
+// readingList returns the papers in the reading list file, looking up any
+// that aren't in the metadata cache.
+func (s arxivSource) readingList() ([]arxivPaper, error) {
+	ids, err := readArxivIDs(s.file)
+	if err != nil {
+		return nil, err
+	}
+
+	known := loadArxivCache()
+	var missing []string
+	for _, id := range ids {
+		if _, ok := known[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	for len(missing) > 0 {
+		batch := missing[:min(arxivBatch, len(missing))]
+		missing = missing[len(batch):]
+
+		found, err := arxivSearch(url.Values{"id_list": {strings.Join(batch, ",")}, "max_results": {fmt.Sprint(len(batch))}})
+		if err != nil {
+			// Without metadata, the paper can still be opened.
+			slog.Info("looking up arxiv papers", "err", err)
+			break
+		}
+		byBase := make(map[string]arxivPaper)
+		for _, p := range found {
+			byBase[arxivBase(p.ID)] = p
+		}
+		for _, id := range batch {
+			// The API always answers with a version; keep the one asked
+			// for, or none for the latest. Ids it doesn't know are
+			// remembered too, so they aren't asked about every time.
+			p := byBase[arxivBase(id)]
+			p.ID = id
+			known[id] = p
+		}
+		if len(missing) > 0 {
+			time.Sleep(3 * time.Second)
+		}
+	}
+	if err := saveArxivCache(known); err != nil {
+		slog.Info("saving arxiv cache", "err", err)
+	}
+
+	var ret []arxivPaper
+	for _, id := range ids {
+		p, ok := known[id]
+		if !ok {
+			p = arxivPaper{ID: id}
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+var arxivVersion = regexp.MustCompile(`v\d+$`)
+
+// arxivBase returns id without its version.
+func arxivBase(id string) string {
+	return arxivVersion.ReplaceAllString(id, "")
+}
+
+// readArxivIDs reads the ids in a reading list, one per line. Blank lines
+// and lines starting with # are skipped.
+func readArxivIDs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ret []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if id := arxivID.FindString(line); id != "" {
+			ret = append(ret, id)
+		} else {
+			slog.Info("not an arxiv id", "path", path, "line", line)
+		}
+	}
+
+	return ret, scanner.Err()
+}
+
+type arxivFeed struct {
+	Entries []struct {
+		ID        string `xml:"id"`
+		Title     string `xml:"title"`
+		Published string `xml:"published"`
+		Authors   []struct {
+			Name string `xml:"name"`
+		} `xml:"author"`
+	} `xml:"entry"`
+}
+
+// arxivSearch calls the API with query, returning the papers it finds.
+func arxivSearch(query url.Values) ([]arxivPaper, error) {
+	req, err := http.NewRequest("GET", arxivAPI+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doHTTP(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var feed arxivFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, err
+	}
+
+	var ret []arxivPaper
+	for _, e := range feed.Entries {
+		// Ids are abs urls, like http://arxiv.org/abs/2101.00001v1.
+		_, id, ok := strings.Cut(e.ID, "/abs/")
+		if !ok {
+			continue
+		}
+
+		p := arxivPaper{
+			ID:        id,
+			Title:     strings.Join(strings.Fields(e.Title), " "),
+			Published: parseFeedTime(e.Published),
+		}
+		for _, a := range e.Authors {
+			p.Authors = append(p.Authors, a.Name)
+		}
+		ret = append(ret, p)
+	}
+
+	return ret, nil
+}
+
+func arxivCachePath() string {
+	return filepath.Join(cacheDir(), "arxiv.json")
+}
+
+// loadArxivCache returns the remembered papers by the ids they were asked
+// for.
+func loadArxivCache() map[string]arxivPaper {
+	ret := make(map[string]arxivPaper)
+
+	buf, err := os.ReadFile(arxivCachePath())
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Info("reading arxiv cache", "err", err)
+		}
+		return ret
+	}
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		slog.Info("reading arxiv cache", "err", err)
+	}
+
+	return ret
+}
+
+func saveArxivCache(papers map[string]arxivPaper) error {
+	buf, err := json.Marshal(papers)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(arxivCachePath()), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(arxivCachePath(), bytes.NewReader(buf))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// A blankChecker is a format that can tell when a page has next to
+// nothing on it.
+type blankChecker interface {
+	// blankPages returns a function reporting whether each page of the
+	// document at path is blank.
+	blankPages(path string) (func(page int) bool, error)
+}
+
+// maxRerolls is how many pages to try in place of a blank one before
+// settling for it.
+const maxRerolls = 5
+
+// paintOps are the content stream operators that put something on the
+// page: text, paths, shadings, and images.
+var paintOps = map[string]bool{
+	"Tj": true, "TJ": true, "'": true, `"`: true,
+	"f": true, "F": true, "f*": true, "S": true, "s": true,
+	"B": true, "B*": true, "b": true, "b*": true,
+	"sh": true, "BI": true, "Do": true,
+}
+
+// blankSample is how many pages a scanned page is compared against.
+const blankSample = 15
+
+// blankPages reports a page as blank when its content draws nothing or,
+// for scans, when its images are a small fraction of the size of the
+// images on a typical page: a blank scan compresses to almost nothing.
+func (pdfFormat) blankPages(path string) (func(page int) bool, error) {
+	doc, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+	n := doc.XRefTable.PageCount
+	xrt := doc.XRefTable
+
+	var typical int64 = -1
+	return func(page int) bool {
+		painted, images, err := pageInk(xrt, page)
+		if err != nil || painted {
+			return false
+		}
+		if images == 0 {
+			return true
+		}
+
+		if typical < 0 {
+			var sizes []int64
+			for i := 0; i < blankSample && i < n; i++ {
+				if _, size, err := pageInk(xrt, 1+i*n/min(n, blankSample)); err == nil && size > 0 {
+					sizes = append(sizes, size)
+				}
+			}
+			slices.Sort(sizes)
+			if typical = 0; len(sizes) > 0 {
+				typical = sizes[len(sizes)/2]
+			}
+		}
+		return images*10 < typical
+	}, nil
+}
+
+// pageInk reads the content of a pdf page, reporting whether it paints
+// anything other than images and how big the images it draws are.
+func pageInk(xrt *model.XRefTable, page int) (painted bool, images int64, err error) {
+	d, _, inherited, err := xrt.PageDict(page, false)
+	if err != nil || d == nil {
+		return false, 0, err
+	}
+	content, err := xrt.PageContent(d)
+	if errors.Is(err, model.ErrNoContent) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
+	resources := d.DictEntry("Resources")
+	if resources == nil && inherited != nil {
+		resources = inherited.Resources
+	}
+	var xobjects types.Dict
+	if resources != nil {
+		if o, ok := resources.Find("XObject"); ok {
+			xobjects, _ = xrt.DereferenceDict(o)
+		}
+	}
+
+	// Text only counts if it shows something other than spaces.
+	var prev string
+	var text bool
+	for _, tok := range contentTokens(content) {
+		if strings.HasPrefix(tok, "(") || strings.HasPrefix(tok, "<") {
+			text = text || strings.TrimSpace(strings.Trim(tok, "()<>")) != ""
+			continue
+		}
+		if !paintOps[tok] {
+			prev = tok
+			continue
+		}
+
+		switch tok {
+		case "Tj", "TJ", "'", `"`:
+			if text {
+				return true, 0, nil
+			}
+			continue
+		case "Do":
+		default:
+			return true, 0, nil
+		}
+
+		// Forms might draw anything, so only images are measured.
+		o, ok := xobjects.Find(strings.TrimPrefix(prev, "/"))
+		if !ok {
+			continue
+		}
+		sd, _, err := xrt.DereferenceStreamDict(o)
+		if err != nil || sd == nil {
+			continue
+		}
+		if subtype := sd.Subtype(); subtype == nil || *subtype != "Image" {
+			return true, 0, nil
+		}
+		if sd.StreamLength != nil {
+			images += *sd.StreamLength
+		} else {
+			images += int64(len(sd.Raw))
+		}
+	}
+
+	return false, images, nil
+}
+
+// contentTokens splits a content stream into operators and operands,
+// keeping strings whole: "(a b)" and "<0102>" are one token each.
+func contentTokens(content []byte) []string {
+	var ret []string
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0:
+			i++
+
+		case c == '(':
+			// Strings nest balanced parentheses, and backslashes escape.
+			j, depth := i+1, 1
+			for ; j < len(content) && depth > 0; j++ {
+				switch content[j] {
+				case '\\':
+					j++
+				case '(':
+					depth++
+				case ')':
+					depth--
+				}
+			}
+			ret = append(ret, string(content[i:min(j, len(content))]))
+			i = j
+
+		case c == '<' && i+1 < len(content) && content[i+1] != '<':
+			j := bytes.IndexByte(content[i:], '>')
+			if j < 0 {
+				j = len(content) - i - 1
+			}
+			ret = append(ret, string(content[i:i+j+1]))
+			i += j + 1
+
+		case strings.IndexByte("[]{}<>", c) >= 0:
+			i++
+
+		default:
+			// Names start with a slash; anything else runs to the next
+			// delimiter.
+			j := i + 1
+			for j < len(content) && strings.IndexByte(" \t\r\n\f\x00()<>[]{}/%", content[j]) < 0 {
+				j++
+			}
+			ret = append(ret, string(content[i:j]))
+			i = j
+		}
+	}
+	return ret
+}
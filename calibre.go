@@ -0,0 +1,127 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// calibreSource lists the books in a Calibre library, with their titles,
+// authors, and tags. calibre: alone uses the default library
+// (~/Calibre Library); calibre:/some/dir names another.
+type calibreSource struct {
+	dir string
+	w   *walker
+}
+
+// calibreFormats is the order of preference among a book's formats. Each
+// book is one candidate, however many formats it comes in.
+var calibreFormats = []string{"PDF", "EPUB", "DJVU", "CBZ", "CBR"}
+
+func newCalibreSource(arg string, w *walker) (source, error) {
+	dir := strings.TrimPrefix(arg, "calibre:")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, "Calibre Library")
+	}
+	return calibreSource{dir: dir, w: w}, nil
+}
+
+const calibreBooks = `
+SELECT
+	b.id,
+	b.title,
+	b.path,
+	coalesce((SELECT group_concat(a.name, '; ') FROM (
+		SELECT a.name FROM books_authors_link l
+			JOIN authors a ON a.id = l.author
+			WHERE l.book = b.id ORDER BY l.id) a), ''),
+	coalesce((SELECT group_concat(t.name, '; ') FROM books_tags_link l
+		JOIN tags t ON t.id = l.tag
+		WHERE l.book = b.id), '')
+FROM books b
+`
+
+func (s calibreSource) list() ([]candidate, error) {
+	db, err := openLibraryDB(filepath.Join(s.dir, "metadata.db"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	files, err := calibreFiles(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(calibreBooks)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []candidate
+	for rows.Next() {
+		var id int
+		var title, dir, authors, tags string
+		if err := rows.Scan(&id, &title, &dir, &authors, &tags); err != nil {
+			return nil, err
+		}
+
+		var path string
+		for _, format := range calibreFormats {
+			if name, ok := files[id][format]; ok {
+				path = filepath.Join(s.dir, filepath.FromSlash(dir), name+"."+strings.ToLower(format))
+				break
+			}
+		}
+		if path == "" || s.w.excluded(s.dir, path, false) {
+			continue
+		}
+
+		c := candidate{Path: path, Title: title}
+		if authors != "" {
+			c.Authors = strings.Split(authors, "; ")
+		}
+		if tags != "" {
+			c.Tags = strings.Split(tags, "; ")
+		}
+		ret = append(ret, c)
+	}
+
+	return ret, rows.Err()
+}
+
+// calibreFiles returns the file names (without extension) of each book's
+// formats, by book id and format.
+func calibreFiles(db *sql.DB) (map[int]map[string]string, error) {
+	rows, err := db.Query(`SELECT book, format, name FROM data`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make(map[int]map[string]string)
+	for rows.Next() {
+		var book int
+		var format, name string
+		if err := rows.Scan(&book, &format, &name); err != nil {
+			return nil, err
+		}
+		if ret[book] == nil {
+			ret[book] = make(map[string]string)
+		}
+		ret[book][strings.ToUpper(format)] = name
+	}
+
+	return ret, rows.Err()
+}
+
+func (s calibreSource) fetch(c candidate, w io.Writer) error {
+	return dirSource{}.fetch(c, w)
+}
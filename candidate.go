@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A candidate is a document that might be picked. Path is usually a local
+// file, but may be a url for a remote source. Documents found inside an archive
+// have Path set to the archive and Member to their name within it.
+type candidate struct {
+	Path   string `json:"path"`
+	Member string `json:"member,omitempty"`
+
+	// Format is the extension of the document's format, when it was
+	// identified by content rather than by name.
+	Format string `json:"format,omitempty"`
+
+	// Title, Authors, and Tags are metadata from sources that keep it,
+	// like a Zotero or Calibre library.
+	Title   string   `json:"title,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+
+	// Size is the document's size in bytes, and ModTime the time it was
+	// last modified, when the source lists them.
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime"`
+
+	// Root is the path or url on the command line the document was found
+	// under.
+	Root string `json:"-"`
+}
+
+func (c candidate) String() string {
+	if c.Member != "" {
+		return c.Path + "/" + c.Member
+	}
+	return c.Path
+}
+
+// abs returns the candidate with an absolute path, if it's local.
+func (c candidate) abs() candidate {
+	if !c.remote() {
+		if path, err := filepath.Abs(c.Path); err == nil {
+			c.Path = path
+		}
+	}
+	return c
+}
+
+// key identifies the document in what's kept about it between runs,
+// wherever it was found from.
+func (c candidate) key() string {
+	return c.abs().String()
+}
+
+// remote reports whether the document has to be downloaded to be opened.
+func (c candidate) remote() bool {
+	return schemeOf(c.Path) != ""
+}
+
+// statLocal returns doc with its size and modification time filled in
+// from the filesystem, if it's a local file and its source didn't list
+// them.
+func statLocal(doc candidate) candidate {
+	if (doc.Size == 0 || doc.ModTime.IsZero()) && doc.Member == "" && !doc.remote() {
+		if info, err := os.Stat(doc.Path); err == nil {
+			doc.Size = info.Size()
+			doc.ModTime = info.ModTime()
+		}
+	}
+	return doc
+}
+
+// name returns the document's file name, which determines its format.
+func (c candidate) name() string {
+	if c.Member != "" {
+		return path.Base(c.Member)
+	}
+	if c.remote() {
+		return urlName(c.Path)
+	}
+	return filepath.Base(c.Path)
+}
+
+// displayName is what to call the document in the viewer: its title and
+// authors if it has them, and its file name otherwise.
+func (c candidate) displayName() string {
+	if c.Title == "" {
+		return c.name()
+	}
+
+	name := c.Title
+	if len(c.Authors) > 0 {
+		name += " - " + strings.Join(c.Authors, ", ")
+	}
+
+	// The name ends up in a url path, so keep it to one element.
+	name = strings.ReplaceAll(name, "/", "-")
+
+	return name + path.Ext(c.name())
+}
+
+// logAttrs returns the attributes to describe the document in log
+// messages.
+func (c candidate) logAttrs() []any {
+	attrs := []any{"path", c.String()}
+	if c.Title != "" {
+		attrs = append(attrs, "title", c.Title)
+	}
+	if len(c.Authors) > 0 {
+		attrs = append(attrs, "authors", strings.Join(c.Authors, ", "))
+	}
+	if len(c.Tags) > 0 {
+		attrs = append(attrs, "tags", strings.Join(c.Tags, ", "))
+	}
+	return attrs
+}
+
+// format returns the document's format, or nil if it can't be told from
+// the candidate alone.
+func (c candidate) format() format {
+	if c.Format != "" {
+		return formats[c.Format]
+	}
+	return formatFor(c.name())
+}
+
+// local returns a path on the local filesystem holding the document's
+// contents, along with a function to clean it up afterward.
+func (c candidate) local() (string, func(), error) {
+	if c.Member != "" {
+		return extractMember(c.Path, c.Member)
+	}
+
+	if c.remote() {
+		path, err := fetchCached(c)
+		return path, func() {}, err
+	}
+
+	return c.Path, func() {}, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// comicFormat handles comic book archives: a zip (.cbz) or rar (.cbr) of
+// page images. Rar archives are read with bsdtar, which ships with macOS.
+type comicFormat struct{}
+
+var comicImageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+	".bmp":  true,
+}
+
+func isRar(archive string) bool {
+	return strings.EqualFold(path.Ext(archive), ".cbr")
+}
+
+// comicPages returns the archive's page images in reading order.
+func comicPages(archive string) ([]string, error) {
+	var names []string
+
+	if isRar(archive) {
+		out, err := exec.Command("bsdtar", "-tf", archive).Output()
+		if err != nil {
+			return nil, fmt.Errorf("bsdtar: %w", err)
+		}
+		names = strings.Split(string(out), "\n")
+	} else {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+	}
+
+	var ret []string
+	for _, name := range names {
+		if comicImageExts[strings.ToLower(path.Ext(name))] && !strings.HasPrefix(path.Base(name), ".") {
+			ret = append(ret, name)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return naturalLess(ret[i], ret[j]) })
+
+	return ret, nil
+}
+
+// naturalLess orders names with the numbers in them compared by value,
+// so an archive's 2.jpg comes before its 10.jpg even unpadded.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return a[i] < b[j]
+			}
+			i++
+			j++
+			continue
+		}
+
+		ei, ej := i, j
+		for ei < len(a) && isDigit(a[ei]) {
+			ei++
+		}
+		for ej < len(b) && isDigit(b[ej]) {
+			ej++
+		}
+		na, nb := strings.TrimLeft(a[i:ei], "0"), strings.TrimLeft(b[j:ej], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		i, j = ei, ej
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	// The same but for padding, like 01.jpg and 1.jpg.
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func readComicPage(archive, name string) ([]byte, error) {
+	if isRar(archive) {
+		out, err := exec.Command("bsdtar", "-xOf", archive, name).Output()
+		if err != nil {
+			return nil, fmt.Errorf("bsdtar: %w", err)
+		}
+		return out, nil
+	}
+
+	r, err := openZipMember(archive, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func (comicFormat) countPages(path string) (int, error) {
+	pages, err := comicPages(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(pages), nil
+}
+
+// open extracts the chosen page image and serves it to the browser, named
+// after the comic and the page.
+func (comicFormat) open(archive, title string, page int) error {
+	pages, err := comicPages(archive)
+	if err != nil {
+		return err
+	}
+	if page < 1 || page > len(pages) {
+		return fmt.Errorf("comic has no page %d", page)
+	}
+	name := pages[page-1]
+
+	buf, err := readComicPage(archive, name)
+	if err != nil {
+		return err
+	}
+
+	ext := path.Ext(name)
+	served := fmt.Sprintf("%s p%d%s", strings.TrimSuffix(title, path.Ext(title)), page, ext)
+
+	return serveBytes(served, mime.TypeByExtension(ext), buf, "")
+}
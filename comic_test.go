@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestNaturalLess(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{
+			names: []string{"10.jpg", "2.jpg", "1.jpg"},
+			want:  []string{"1.jpg", "2.jpg", "10.jpg"},
+		},
+		{
+			names: []string{"page010.png", "page009.png", "page1.png"},
+			want:  []string{"page1.png", "page009.png", "page010.png"},
+		},
+		{
+			names: []string{"ch2/10.jpg", "ch10/1.jpg", "ch2/9.jpg"},
+			want:  []string{"ch2/9.jpg", "ch2/10.jpg", "ch10/1.jpg"},
+		},
+		{
+			names: []string{"1.jpg", "01.jpg", "cover.jpg", "1a.jpg"},
+			want:  []string{"01.jpg", "1.jpg", "1a.jpg", "cover.jpg"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := slices.Clone(tt.names)
+		sort.Slice(got, func(i, j int) bool { return naturalLess(got[i], got[j]) })
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("sorting %q = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commands are the things randpage can do besides opening a document,
+// named by the first argument. A path with the same name as a command can
+// be given as ./name.
+var commands = map[string]func(args []string) error{
+	"revisit": revisitCommand,
+	"forget":  forgetCommand,
+	"tag":     tagCommand,
+	"ban":     banCommand,
+	"unban":   unbanCommand,
+	"snooze":  snoozeCommand,
+	"dismiss": dismissCommand,
+	"rate":    rateCommand,
+	"pin":     pinCommand,
+	"unpin":   unpinCommand,
+	"read":    readCommand,
+	"undo":    undoCommand,
+	"note":    noteCommand,
+	"notes":   notesCommand,
+	"done":    doneCommand,
+	"undone":  undoneCommand,
+	"stats":   statsCommand,
+	"history": historyCommand,
+	"prune":   pruneCommand,
+	"export":  exportCommand,
+	"state":   stateCommand,
+}
+
+// revisitCommand schedules the last document picked to come back, at an
+// interval that grows each time it's revisited: randpage revisit [-page]
+// [grade], where grade is how well it went, from 0 to 5 (default 4). A
+// grade below 3 starts the intervals over. With -page, it's the page the
+// document was opened to that comes back, like a flashcard.
+func revisitCommand(args []string) error {
+	fs := flag.NewFlagSet("revisit", flag.ContinueOnError)
+	page := fs.Bool("page", false, "schedule the page the last document was opened to instead of the document")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	args = fs.Args()
+
+	q := 4
+	if len(args) > 0 {
+		var err error
+		if q, err = strconv.Atoi(args[0]); err != nil || q < 0 || q > 5 {
+			return fmt.Errorf("revisit: grade must be 0 to 5, not %q", args[0])
+		}
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if st.Last == nil {
+		return fmt.Errorf("revisit: nothing has been picked yet")
+	}
+
+	var c *card
+	what := st.Last.Doc.String()
+	if *page {
+		c = st.gradePage(st.Last.Doc, st.Last.Page, q, time.Now())
+		what = fmt.Sprintf("%s page %d", what, st.Last.Page)
+	} else {
+		c = st.grade(st.Last.Doc, q, time.Now())
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s: back on %s\n", what, c.Due.Format("Mon Jan 2"))
+	return nil
+}
+
+// forgetCommand takes the last document picked off the revisiting
+// schedule: randpage forget [-page], where -page takes off the page it
+// was opened to instead.
+func forgetCommand(args []string) error {
+	fs := flag.NewFlagSet("forget", flag.ContinueOnError)
+	page := fs.Bool("page", false, "take the page the last document was opened to off the schedule instead of the document")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if st.Last == nil {
+		return fmt.Errorf("forget: nothing has been picked yet")
+	}
+
+	if *page {
+		i := st.pageCard(st.Last.Doc, st.Last.Page)
+		if i < 0 {
+			return fmt.Errorf("forget: %s page %d isn't scheduled", st.Last.Doc, st.Last.Page)
+		}
+		st.PageCards = slices.Delete(st.PageCards, i, i+1)
+		st.touch(kindPageCards, pageKey(st.Last.Doc, st.Last.Page))
+	} else {
+		delete(st.Cards, st.Last.Doc.key())
+		st.touch(kindCards, st.Last.Doc.key())
+	}
+	return st.save()
+}
+
+// tagCommand organizes documents by topic: randpage tag add|rm <file>
+// <tag>... gives or takes away tags, and randpage tag ls [file] lists the
+// tags of one document or of all the tagged ones.
+func tagCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: randpage tag add|rm <file> <tag>... or randpage tag ls [file]")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	switch verb, args := args[0], args[1:]; verb {
+	case "add", "rm":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: randpage tag %s <file> <tag>...", verb)
+		}
+		doc, err := argOrLast(st, args[:1])
+		if err != nil {
+			return err
+		}
+		for _, t := range args[1:] {
+			if verb == "add" {
+				st.tag(doc, t)
+			} else if !st.untag(doc, t) {
+				fmt.Fprintf(os.Stderr, "randpage: %s isn't tagged %s\n", doc, t)
+			}
+		}
+		return st.save()
+
+	case "ls":
+		if len(args) > 0 {
+			fmt.Println(strings.Join(st.Tags[candidate{Path: args[0]}.key()], " "))
+			return nil
+		}
+		keys := make([]string, 0, len(st.Tags))
+		for key := range st.Tags {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s\t%s\n", key, strings.Join(st.Tags[key], " "))
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("tag: unknown command %q", verb)
+	}
+}
+
+// argOrLast returns the document named by args, or the last one picked if
+// there aren't any.
+func argOrLast(st *state, args []string) (candidate, error) {
+	if len(args) > 0 {
+		doc := candidate{Path: args[0]}
+		if !doc.remote() {
+			if _, err := os.Stat(doc.Path); err != nil {
+				return candidate{}, err
+			}
+		}
+		return doc, nil
+	}
+
+	if st.Last == nil {
+		return candidate{}, fmt.Errorf("nothing has been picked yet")
+	}
+	return st.Last.Doc, nil
+}
+
+// banCommand keeps a document from ever being picked again: randpage ban
+// [file], the last document picked by default.
+func banCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, args)
+	if err != nil {
+		return fmt.Errorf("ban: %w", err)
+	}
+
+	if st.Banned == nil {
+		st.Banned = make(map[string]bool)
+	}
+	st.Banned[doc.key()] = true
+	st.touch(kindBanned, doc.key())
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: banned\n", doc)
+	return nil
+}
+
+// unbanCommand lets a banned document be picked again: randpage unban
+// [file], or randpage unban with no arguments to list the banned ones.
+func unbanCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		keys := make([]string, 0, len(st.Banned))
+		for key := range st.Banned {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+		return nil
+	}
+
+	key := candidate{Path: args[0]}.key()
+	if !st.Banned[key] {
+		return fmt.Errorf("unban: %s isn't banned", args[0])
+	}
+	delete(st.Banned, key)
+	st.touch(kindBanned, key)
+	return st.save()
+}
+
+// readCommand confirms the last pick was read, for --streak-read.
+func readCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: randpage read")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if st.Last == nil {
+		return fmt.Errorf("read: nothing has been picked yet")
+	}
+
+	st.Last.Read = true
+	for i := len(st.History) - 1; i >= 0; i-- {
+		if p := &st.History[i]; p.Doc.key() == st.Last.Doc.key() && p.Page == st.Last.Page && p.Time.Equal(st.Last.Time) {
+			p.Read = true
+			break
+		}
+	}
+	return st.save()
+}
+
+// pruneCommand drops the picks the history retention doesn't keep, which
+// happens anyway whenever the state is loaded, and reports how many:
+// randpage prune [-keep age|forever] [-max count], where the flags
+// override the config's retention for once.
+func pruneCommand(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	keep := fs.String("keep", "", "keep the picks within `age` (like 2y), or forever")
+	maxEntries := fs.Int("max", 0, "keep at most `count` picks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: randpage prune [-keep age] [-max count]")
+	}
+
+	r := historyRetention
+	if *keep != "" {
+		keepAll, err := newRetention(*keep, 0)
+		if err != nil {
+			return fmt.Errorf("prune: %w", err)
+		}
+		r.keep = keepAll.keep
+	}
+	if *maxEntries < 0 {
+		return fmt.Errorf("prune: invalid maximum %d", *maxEntries)
+	}
+	if *maxEntries > 0 {
+		r.max = *maxEntries
+	}
+
+	st, err := stateStore.load()
+	if err != nil {
+		return err
+	}
+	n := st.prune(r, time.Now())
+	if n == 0 {
+		fmt.Println("nothing to prune")
+		return nil
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+	fmt.Printf("pruned %d picks, kept %d\n", n, len(st.History))
+	return nil
+}
+
+// undoCommand takes back the last pick, for one made by mistake or whose
+// viewer never opened, so it doesn't count in stats or as seen.
+func undoCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: randpage undo")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	p, ok := st.undo()
+	if !ok {
+		return fmt.Errorf("undo: nothing has been picked yet")
+	}
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: undid page %d\n", p.Doc, p.Page)
+	return nil
+}
+
+// noteCommand writes a note on the page the last document was opened to:
+// randpage note <text>...
+func noteCommand(args []string) error {
+	text := strings.TrimSpace(strings.Join(args, " "))
+	if text == "" {
+		return fmt.Errorf("usage: randpage note <text>...")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if st.Last == nil {
+		return fmt.Errorf("note: nothing has been picked yet")
+	}
+
+	st.Notes = append(st.Notes, note{Doc: st.Last.Doc, Page: st.Last.Page, Time: time.Now(), Text: text})
+	return st.save()
+}
+
+// notesCommand lists the notes on a document, by page, or on every
+// document with no arguments: randpage notes [file].
+func notesCommand(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: randpage notes [file]")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	notes := slices.Clone(st.Notes)
+	if len(args) == 1 {
+		key := candidate{Path: args[0]}.key()
+		notes = slices.DeleteFunc(notes, func(n note) bool { return n.Doc.key() != key })
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		if a, b := notes[i].Doc.String(), notes[j].Doc.String(); a != b {
+			return a < b
+		}
+		return notes[i].Page < notes[j].Page
+	})
+
+	last := ""
+	for _, n := range notes {
+		if doc := n.Doc.String(); doc != last {
+			if last != "" {
+				fmt.Println()
+			}
+			fmt.Println(doc)
+			last = doc
+		}
+		fmt.Printf("  p. %d, %s: %s\n", n.Page, n.Time.Local().Format("2006-01-02"), n.Text)
+	}
+	return nil
+}
+
+// doneCommand marks a document finished, so it's no longer picked
+// without --include-done: randpage done [file], the last document picked
+// by default.
+func doneCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, args)
+	if err != nil {
+		return fmt.Errorf("done: %w", err)
+	}
+
+	if st.Done == nil {
+		st.Done = make(map[string]time.Time)
+	}
+	st.Done[doc.key()] = time.Now()
+	st.touch(kindDone, doc.key())
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: done\n", doc)
+	return nil
+}
+
+// undoneCommand puts a document marked done back in the library:
+// randpage undone [file], or randpage undone with no arguments to list
+// the finished ones, oldest first.
+func undoneCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		keys := make([]string, 0, len(st.Done))
+		for key := range st.Done {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return st.Done[keys[i]].Before(st.Done[keys[j]]) })
+		for _, key := range keys {
+			fmt.Printf("%s\t%s\n", st.Done[key].Format(time.DateOnly), key)
+		}
+		return nil
+	}
+
+	key := candidate{Path: args[0]}.key()
+	if _, ok := st.Done[key]; !ok {
+		return fmt.Errorf("undone: %s isn't done", args[0])
+	}
+	delete(st.Done, key)
+	st.touch(kindDone, key)
+	return st.save()
+}
+
+// snoozeCommand keeps a document from being picked for a while: randpage
+// snooze [file] [age], the last document picked for 30 days by default.
+// An age of 0 wakes it up.
+func snoozeCommand(args []string) error {
+	var d age
+	if err := d.Set("30d"); err != nil {
+		return err
+	}
+	if n := len(args); n > 0 {
+		if err := d.Set(args[n-1]); err == nil {
+			args = args[:n-1]
+		} else if n > 1 {
+			return fmt.Errorf("snooze: %w", err)
+		}
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, args)
+	if err != nil {
+		return fmt.Errorf("snooze: %w", err)
+	}
+
+	until := time.Now().Add(time.Duration(d))
+	st.snooze(doc, until)
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	if d == 0 {
+		fmt.Printf("%s: awake\n", doc)
+	} else {
+		fmt.Printf("%s: snoozed until %s\n", doc, until.Format("Mon Jan 2"))
+	}
+	return nil
+}
+
+// dismissCommand makes a document half as likely to be picked, as if it
+// had been closed within --dismiss-within: randpage dismiss [file], the
+// last document picked by default.
+func dismissCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, args)
+	if err != nil {
+		return fmt.Errorf("dismiss: %w", err)
+	}
+
+	st.dismissed(doc)
+	return st.save()
+}
+
+// rateCommand rates a document from 1 to 5: randpage rate <1-5> [file],
+// the last document picked by default. Higher rated documents come up
+// more often. A rating of 0 takes the rating away.
+func rateCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: randpage rate <1-5> [file]")
+	}
+	rating, err := strconv.Atoi(args[0])
+	if err != nil || rating < 0 || rating > 5 {
+		return fmt.Errorf("rate: invalid rating %q (want 1 to 5, or 0 to take it away)", args[0])
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, args[1:])
+	if err != nil {
+		return fmt.Errorf("rate: %w", err)
+	}
+
+	st.rate(doc, rating)
+	return st.save()
+}
+
+// pinCommand makes every pick come from one document, until randpage
+// unpin: randpage pin [-sequential] [file], the last document picked by
+// default. With -sequential, each pick is the page after the last
+// instead of a random one.
+func pinCommand(args []string) error {
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	sequential := fs.Bool("sequential", false, "open each page after the last instead of a random one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	doc, err := argOrLast(st, fs.Args())
+	if err != nil {
+		return fmt.Errorf("pin: %w", err)
+	}
+
+	st.Pin = &pin{Doc: doc.abs(), Sequential: *sequential}
+	st.touch(kindPin, "")
+	if err := st.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: pinned\n", doc)
+	return nil
+}
+
+// unpinCommand goes back to picking from the whole library.
+func unpinCommand(args []string) error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	if st.Pin == nil {
+		return fmt.Errorf("unpin: %w", errNotPinned)
+	}
+
+	st.Pin = nil
+	st.touch(kindPin, "")
+	return st.save()
+}
+
+// stateCommand copies the state in and out, to back it up or move it to
+// another machine: randpage state export prints it as JSON, in the JSON
+// store's format, and randpage state import [file] replaces it with what
+// the file (or stdin) holds. randpage state sync merges it with the
+// shared copy without picking anything.
+func stateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: randpage state export|sync or randpage state import [file]")
+	}
+
+	switch verb, args := args[0], args[1:]; verb {
+	case "export":
+		if len(args) > 0 {
+			return fmt.Errorf("usage: randpage state export")
+		}
+		st, err := loadState()
+		if err != nil {
+			return err
+		}
+		buf, err := encodeState(st)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(buf, '\n'))
+		return err
+
+	case "import":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: randpage state import [file]")
+		}
+		name, r := "stdin", io.Reader(os.Stdin)
+		if len(args) == 1 {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			name, r = args[0], f
+		}
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		st, err := decodeState(buf)
+		if err != nil {
+			return fmt.Errorf("state import: %s: %w", name, err)
+		}
+		// Saving would merge in the shared copy, which is what it's
+		// replacing.
+		if s, ok := stateStore.(syncStore); ok {
+			st.Saved = time.Now()
+			return s.replace(st)
+		}
+		return st.save()
+
+	case "sync":
+		if _, ok := stateStore.(syncStore); !ok {
+			return fmt.Errorf("state sync: no sync file is set in the config")
+		}
+		st, err := loadState()
+		if err != nil {
+			return err
+		}
+		return st.save()
+
+	default:
+		return fmt.Errorf("state: unknown command %q", verb)
+	}
+}
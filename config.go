@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// A config holds the settings from randpage's config file. Library
+// options are defaults for the corresponding flags.
+type config struct {
+	// Roots are the paths and sources to pick from when none are given on
+	// the command line.
+	Roots []string `toml:"roots"`
+
+	Exclude        []string `toml:"exclude"`
+	Match          []string `toml:"match"`
+	NoMatch        []string `toml:"no_match"`
+	FollowSymlinks bool     `toml:"follow_symlinks"`
+	IncludeHidden  bool     `toml:"include_hidden"`
+	MaxDepth       int      `toml:"max_depth"`
+	Jobs           int      `toml:"jobs"`
+	DirTimeout     string   `toml:"dir_timeout"`
+	FileTimeout    string   `toml:"file_timeout"`
+	Sniff          string   `toml:"sniff"`
+	MinSize        string   `toml:"min_size"`
+	MaxSize        string   `toml:"max_size"`
+	NewerThan      string   `toml:"newer_than"`
+	OlderThan      string   `toml:"older_than"`
+	KeepDuplicates bool     `toml:"keep_duplicates"`
+	Strategy       string   `toml:"strategy"`
+	Weight         string   `toml:"weight"` // the old name for strategy
+	Balance        string   `toml:"balance"`
+	MinPages       int      `toml:"min_pages"`
+	MaxPages       int      `toml:"max_pages"`
+	SkipRecent     string   `toml:"skip_recent"`
+	RecentBias     string   `toml:"recent_bias"`
+	PreferUnopened bool     `toml:"prefer_unopened"`
+	IncludeDone    bool     `toml:"include_done"`
+	StreakRead     bool     `toml:"streak_read"`
+	Tags           []string `toml:"tags"`
+	Continue       bool     `toml:"continue"`
+	Sections       bool     `toml:"sections"`
+	Chapters       bool     `toml:"chapters"`
+	SkipBlank      bool     `toml:"skip_blank"`
+	SkipAnnotated  bool     `toml:"skip_annotated"`
+	SkipFront      int      `toml:"skip_front"`
+	SkipBack       int      `toml:"skip_back"`
+	CryptoRand     bool     `toml:"crypto_rand"`
+	Daily          bool     `toml:"daily"`
+	Spread         int      `toml:"spread"`
+	Extract        bool     `toml:"extract"`
+	DismissWithin  string   `toml:"dismiss_within"`
+
+	// Matter maps patterns for documents and directories to the pages of
+	// front and back matter to skip in them.
+	Matter map[string]matterConfig `toml:"matter"`
+
+	// Priority maps patterns for documents and directories to weights
+	// that multiply their chance of being picked.
+	Priority map[string]float64 `toml:"priority"`
+
+	// Quota maps category names to the share of picks to give the
+	// documents in them.
+	Quota map[string]quotaConfig `toml:"quota"`
+
+	// Goals are the reading goals to report on.
+	Goals []goalConfig `toml:"goal"`
+
+	// Viewer is the command that opens documents, in place of the
+	// platform's url opener: a template where {path}, {page}, and {url}
+	// stand for the document's file, page, and url.
+	Viewer string `toml:"viewer"`
+
+	// Viewers are the viewers to try in order, like Viewer, when it isn't
+	// set, in place of the native viewers found installed.
+	Viewers []string `toml:"viewers"`
+
+	// ImageViewer is the command that opens pages drawn with --as-image,
+	// in place of the platform's opener, with {path} for the image.
+	ImageViewer string `toml:"image_viewer"`
+
+	// SpeakCommand is the command that reads --speak's text aloud from its
+	// input, in place of the platform's text to speech.
+	SpeakCommand string `toml:"speak_command"`
+
+	// Anki is where --anki adds notes.
+	Anki struct {
+		Enabled bool   `toml:"enabled"`
+		URL     string `toml:"url"`
+		Deck    string `toml:"deck"`
+		Model   string `toml:"model"`
+	} `toml:"anki"`
+
+	// Obsidian is the daily note to log picks in. DailyNote is its path,
+	// with {date} for the date in DateFormat, a Go time layout.
+	Obsidian struct {
+		DailyNote  string `toml:"daily_note"`
+		DateFormat string `toml:"date_format"`
+	} `toml:"obsidian"`
+
+	// History is how much history to keep: the picks within Keep, an
+	// age or "forever", and at most MaxEntries of them.
+	History struct {
+		Keep       string `toml:"keep"`
+		MaxEntries int    `toml:"max_entries"`
+	} `toml:"history"`
+
+	// State chooses where the state is kept.
+	State stateConfig `toml:"state"`
+
+	// Credentials for sources. The environment variables take precedence.
+	WebDAV struct {
+		User     string `toml:"user"`
+		Password string `toml:"password"`
+	} `toml:"webdav"`
+	Dropbox struct {
+		Token string `toml:"token"`
+	} `toml:"dropbox"`
+	GDrive struct {
+		Token string `toml:"token"`
+		Key   string `toml:"key"`
+	} `toml:"gdrive"`
+	Paperless struct {
+		URL   string `toml:"url"`
+		Token string `toml:"token"`
+	} `toml:"paperless"`
+
+	// Profiles are named sets of settings, chosen with --profile, that
+	// override the ones above.
+	Profiles map[string]toml.Primitive `toml:"profiles"`
+}
+
+// A stateConfig chooses the store for the state: a SQLite database or,
+// to keep under version control, a JSON file.
+type stateConfig struct {
+	Backend string `toml:"backend"`
+	Path    string `toml:"path"`
+
+	// Sync is a file shared with other machines to merge the state with.
+	Sync string `toml:"sync"`
+
+	// Encrypt is how to encrypt the state: "passphrase" or "keychain".
+	// PassphraseCommand prints the passphrase, if it isn't in
+	// $RANDPAGE_PASSPHRASE.
+	Encrypt           string `toml:"encrypt"`
+	PassphraseCommand string `toml:"passphrase_command"`
+}
+
+// cfg is the loaded config file.
+var cfg config
+
+func defaultConfigPath() string {
+	return filepath.Join(configDir(), "config.toml")
+}
+
+// loadConfig reads the config file at path, applying the named profile
+// if there is one. A missing file is an empty config.
+func loadConfig(path, profile string) (config, error) {
+	var ret config
+
+	md, err := toml.DecodeFile(path, &ret)
+	if errors.Is(err, fs.ErrNotExist) && profile == "" {
+		return config{}, nil
+	}
+	if err != nil {
+		return config{}, err
+	}
+
+	if profile != "" {
+		p, ok := ret.Profiles[profile]
+		if !ok {
+			return config{}, fmt.Errorf("%s: no profile named %q", path, profile)
+		}
+		// Decoding over the top-level settings replaces only the ones the
+		// profile sets. Quotas only make sense together, so a profile's
+		// replace the top-level ones rather than adding to them.
+		if md.IsDefined("profiles", profile, "quota") {
+			ret.Quota = nil
+		}
+		if err := md.PrimitiveDecode(p, &ret); err != nil {
+			return config{}, fmt.Errorf("%s: profile %q: %w", path, profile, err)
+		}
+	}
+
+	for i, root := range ret.Roots {
+		ret.Roots[i] = expandHome(root)
+	}
+
+	return ret, nil
+}
+
+// expandHome replaces a leading ~/ (or on Windows, ~\) with the home
+// directory.
+func expandHome(path string) string {
+	if len(path) < 2 || path[0] != '~' || !os.IsPathSeparator(path[1]) {
+		return path
+	}
+	rest := path[2:]
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, rest)
+}
+
+// matterConfig is a [matter] table.
+type matterConfig struct {
+	Front int `toml:"front"`
+	Back  int `toml:"back"`
+}
+
+// quotaConfig is a [quota] table: a category of documents, by tag or by
+// path pattern.
+type quotaConfig struct {
+	Share float64  `toml:"share"`
+	Tags  []string `toml:"tags"`
+	Paths []string `toml:"paths"`
+}
+
+// envOr returns the environment variable called name, or fallback if it's
+// unset.
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// dedupBlock is how much of the start and end of a document is hashed to
+// tell it apart from others of the same size.
+const dedupBlock = 64 << 10
+
+// dedup collapses copies of the same local document, keeping the first,
+// so a document on disk in several places isn't more likely to be picked.
+// Documents are compared by size, then by a hash of their first and last
+// blocks, so only documents that share a size are read at all. Remote
+// documents are left alone.
+func dedup(docs []candidate) []candidate {
+	bySize := make(map[int64][]int)
+	for i, doc := range docs {
+		if doc.remote() {
+			continue
+		}
+		if size := docSize(doc); size > 0 {
+			bySize[size] = append(bySize[size], i)
+		}
+	}
+
+	drop := make(map[int]bool)
+	for size, group := range bySize {
+		if len(group) < 2 {
+			continue
+		}
+
+		seen := make(map[[sha256.Size]byte]int)
+		for _, i := range group {
+			sum, err := partialHash(docs[i], size)
+			if err != nil {
+				slog.Info("hashing document", "path", docs[i], "err", err)
+				continue
+			}
+
+			if first, ok := seen[sum]; ok {
+				slog.Info("skipping duplicate document", "path", docs[i], "of", docs[first])
+				drop[i] = true
+				continue
+			}
+			seen[sum] = i
+		}
+	}
+
+	if len(drop) == 0 {
+		return docs
+	}
+
+	ret := make([]candidate, 0, len(docs)-len(drop))
+	for i, doc := range docs {
+		if !drop[i] {
+			ret = append(ret, doc)
+		}
+	}
+	return ret
+}
+
+// docSize returns the size of a local document, or zero if it can't be
+// found.
+func docSize(doc candidate) int64 {
+	if doc.Size != 0 || doc.Member != "" {
+		return doc.Size
+	}
+
+	info, err := os.Stat(doc.Path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// partialHash hashes the first and last blocks of a document of the given
+// size.
+func partialHash(doc candidate, size int64) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	var r io.ReadCloser
+	var err error
+	if doc.Member != "" {
+		r, err = openMember(doc.Path, doc.Member)
+	} else {
+		r, err = os.Open(doc.Path)
+	}
+	if err != nil {
+		return sum, err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, dedupBlock); err != nil && err != io.EOF {
+		return sum, err
+	}
+
+	if size > dedupBlock {
+		// Archive members can't seek, so they're read through to the
+		// last block.
+		tail := max(dedupBlock, size-dedupBlock)
+		if s, ok := r.(io.Seeker); ok {
+			_, err = s.Seek(tail, io.SeekStart)
+		} else {
+			_, err = io.CopyN(io.Discard, r, tail-dedupBlock)
+		}
+		if err != nil {
+			return sum, err
+		}
+
+		if _, err := io.Copy(h, r); err != nil {
+			return sum, err
+		}
+	}
+
+	h.Sum(sum[:0])
+	return sum, nil
+}
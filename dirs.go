@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// configDir returns randpage's configuration directory, following the XDG
+// base directory convention on every platform.
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "randpage")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".config", "randpage")
+	}
+
+	return filepath.Join(home, ".config", "randpage")
+}
+
+// cacheDir returns the directory for downloaded documents and other data
+// that's safe to delete.
+func cacheDir() string {
+	if dir := os.Getenv("XDG_CACHE_HOME"); dir != "" {
+		return filepath.Join(dir, "randpage")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".cache", "randpage")
+	}
+
+	return filepath.Join(home, ".cache", "randpage")
+}
+
+// stateDir returns the directory for what randpage remembers between runs,
+// like which documents it has picked.
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "randpage")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".local", "state", "randpage")
+	}
+
+	return filepath.Join(home, ".local", "state", "randpage")
+}
+
+// dataDir returns the directory for randpage's own databases.
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "randpage")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".local", "share", "randpage")
+	}
+
+	return filepath.Join(home, ".local", "share", "randpage")
+}
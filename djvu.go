@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// djvuFormat uses the djvulibre command line tools. Browsers can't display
+// djvu, so the chosen page is converted to a one-page pdf and served like
+// any other pdf.
+type djvuFormat struct{}
+
+func (djvuFormat) countPages(path string) (int, error) {
+	out, err := exec.Command("djvused", "-e", "n", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("djvused: %w", err)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(out)))
+}
+
+func (djvuFormat) open(path, name string, page int) error {
+	dir, err := os.MkdirTemp("", "randpage")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+".pdf")
+
+	cmd := exec.Command("ddjvu", "-format=pdf", "-page="+strconv.Itoa(page), path, out)
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ddjvu: %w: %s", err, strings.TrimSpace(string(msg)))
+	}
+
+	return pdfFormat{}.open(out, filepath.Base(out), 1)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dropboxSource lists a Dropbox folder through the API, so documents the
+// desktop client hasn't synced (or that aren't on this machine at all) can
+// still be picked. dropbox:/Books/Papers names a folder; dropbox: alone is
+// the whole Dropbox. The access token comes from RANDPAGE_DROPBOX_TOKEN or
+// the config file.
+type dropboxSource struct {
+	folder string
+	w      *walker
+}
+
+func newDropboxSource(arg string, w *walker) (source, error) {
+	folder := strings.TrimSuffix(strings.TrimPrefix(arg, "dropbox:"), "/")
+	if folder != "" && !strings.HasPrefix(folder, "/") {
+		folder = "/" + folder
+	}
+	return dropboxSource{folder: folder, w: w}, nil
+}
+
+type dropboxListResult struct {
+	Entries []struct {
+		Tag         string    `json:".tag"`
+		PathDisplay string    `json:"path_display"`
+		Size        int64     `json:"size"`
+		Modified    time.Time `json:"server_modified"`
+	} `json:"entries"`
+	Cursor  string `json:"cursor"`
+	HasMore bool   `json:"has_more"`
+}
+
+func (s dropboxSource) list() ([]candidate, error) {
+	var ret []candidate
+
+	endpoint := "https://api.dropboxapi.com/2/files/list_folder"
+	var arg any = map[string]any{"path": s.folder, "recursive": true}
+	for {
+		var result dropboxListResult
+		if err := dropboxRPC(endpoint, arg, &result); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range result.Entries {
+			if entry.Tag == "file" && s.w.keepRemote(s.folder, entry.PathDisplay) {
+				ret = append(ret, candidate{Path: "dropbox:" + entry.PathDisplay, Size: entry.Size, ModTime: entry.Modified})
+			}
+		}
+
+		if !result.HasMore {
+			return ret, nil
+		}
+		endpoint = "https://api.dropboxapi.com/2/files/list_folder/continue"
+		arg = map[string]any{"cursor": result.Cursor}
+	}
+}
+
+func (s dropboxSource) fetch(c candidate, w io.Writer) error {
+	req, err := http.NewRequest("POST", "https://content.dropboxapi.com/2/files/download", nil)
+	if err != nil {
+		return err
+	}
+
+	arg, err := json.Marshal(map[string]string{"path": strings.TrimPrefix(c.Path, "dropbox:")})
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Dropbox-API-Arg", asciiJSON(arg))
+
+	resp, err := dropboxDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// dropboxRPC calls an RPC-style endpoint with a JSON argument, decoding the
+// JSON result into v.
+func dropboxRPC(endpoint string, arg, v any) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := dropboxDo(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func dropboxDo(req *http.Request) (*http.Response, error) {
+	token := envOr("RANDPAGE_DROPBOX_TOKEN", cfg.Dropbox.Token)
+	if token == "" {
+		return nil, errors.New("dropbox: set RANDPAGE_DROPBOX_TOKEN or [dropbox] token in the config file")
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return doHTTP(req)
+}
+
+// asciiJSON escapes the non-ASCII characters in a JSON document, since
+// Dropbox-API-Arg is an HTTP header.
+func asciiJSON(buf []byte) string {
+	var b strings.Builder
+	for _, r := range string(buf) {
+		if r < 0x80 {
+			b.WriteRune(r)
+		} else if r <= 0xffff {
+			fmt.Fprintf(&b, `\u%04x`, r)
+		} else {
+			r -= 0x10000
+			fmt.Fprintf(&b, `\u%04x\u%04x`, 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// encryptedStore keeps the state in a file encrypted with AES-256-GCM,
+// under a key from a passphrase or the OS keychain. If there's no file
+// yet, it starts from the state in legacy, if there is one.
+type encryptedStore struct {
+	path   string
+	key    keySource
+	legacy store
+}
+
+// The encrypted file is a header, then the state in the JSON store's
+// format, sealed. The header is authenticated along with it.
+const (
+	encryptedMagic = "randpage-state-1\n"
+	saltSize       = 16
+	headerSize     = len(encryptedMagic) + 1 + 4 + saltSize
+)
+
+// How the key is made from the secret, in the header.
+const (
+	kdfNone   = 0 // the secret is the key
+	kdfPBKDF2 = 1 // PBKDF2-HMAC-SHA256 of a passphrase
+)
+
+// pbkdf2Iterations is how hard the key is to guess from the passphrase.
+const pbkdf2Iterations = 600000
+
+func (s encryptedStore) load() (*state, error) {
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if s.legacy != nil {
+			return s.legacy.load()
+		}
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := s.open(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	st, err := decodeState(plain)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return st, nil
+}
+
+func (s encryptedStore) save(st *state) error {
+	plain, err := encodeState(st)
+	if err != nil {
+		return err
+	}
+
+	// Keep the file's salt, so the key made loading it can be used again.
+	header := make([]byte, headerSize)
+	if buf, err := os.ReadFile(s.path); err == nil && len(buf) >= headerSize && string(buf[:len(encryptedMagic)]) == encryptedMagic {
+		copy(header, buf)
+	} else {
+		copy(header, encryptedMagic)
+		binary.BigEndian.PutUint32(header[len(encryptedMagic)+1:], pbkdf2Iterations)
+		if _, err := rand.Read(header[headerSize-saltSize:]); err != nil {
+			return err
+		}
+	}
+	header[len(encryptedMagic)] = s.key.kdf()
+
+	aead, err := s.cipher(header, true)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := append(append(header, nonce...), aead.Seal(nil, nonce, plain, header)...)
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, bytes.NewReader(out)); err != nil {
+		return err
+	}
+
+	// The state is in the encrypted file now, so it mustn't be left
+	// readable in the files it started from.
+	removePlaintext(s.legacy)
+	return nil
+}
+
+// removePlaintext removes the files of the unencrypted store s and of
+// the stores it started from, warning about any it can't.
+func removePlaintext(s store) {
+	for s != nil {
+		var paths []string
+		switch t := s.(type) {
+		case jsonStore:
+			paths, s = []string{t.path}, t.legacy
+		case sqliteStore:
+			paths, s = []string{t.path, t.path + "-journal", t.path + "-wal", t.path + "-shm"}, t.legacy
+		default:
+			return
+		}
+		for _, path := range paths {
+			switch err := os.Remove(path); {
+			case err == nil:
+				slog.Warn("removed the unencrypted state, now that it's encrypted", "path", path)
+			case !errors.Is(err, fs.ErrNotExist):
+				slog.Warn("couldn't remove the unencrypted state", "path", path, "err", err)
+			}
+		}
+	}
+}
+
+// open decrypts the file buf.
+func (s encryptedStore) open(buf []byte) ([]byte, error) {
+	if len(buf) < headerSize || string(buf[:len(encryptedMagic)]) != encryptedMagic {
+		return nil, errors.New("not an encrypted state file")
+	}
+	header := buf[:headerSize]
+	aead, err := s.cipher(header, false)
+	if err != nil {
+		return nil, err
+	}
+	rest := buf[headerSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("truncated")
+	}
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], header)
+	if err != nil {
+		return nil, errors.New("can't decrypt it: wrong passphrase or key, or the file is damaged")
+	}
+	return plain, nil
+}
+
+// fileKeys are the keys made for each header this run, since making one
+// from a passphrase is slow on purpose.
+var fileKeys = make(map[string][]byte)
+
+// cipher returns the AEAD for the file with header, creating a key in the
+// keychain if there isn't one and create is set.
+func (s encryptedStore) cipher(header []byte, create bool) (cipher.AEAD, error) {
+	key, ok := fileKeys[string(header)]
+	if !ok {
+		kdf := header[len(encryptedMagic)]
+		iterations := int(binary.BigEndian.Uint32(header[len(encryptedMagic)+1:]))
+		salt := header[headerSize-saltSize:]
+
+		secret, err := s.key.secret(create)
+		if err != nil {
+			return nil, err
+		}
+		switch kdf {
+		case kdfNone:
+			key = secret
+		case kdfPBKDF2:
+			key = pbkdf2(secret, salt, iterations, 32)
+		default:
+			return nil, fmt.Errorf("unknown key derivation %d", kdf)
+		}
+		if len(key) != 32 {
+			return nil, errors.New("the key isn't 32 bytes")
+		}
+		fileKeys[string(header)] = key
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key of n bytes from password, per RFC 8018 with
+// HMAC-SHA256.
+func pbkdf2(password, salt []byte, iterations, n int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < n; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := bytes.Clone(u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:n]
+}
+
+// A keySource is where the state's key comes from: a passphrase, from
+// $RANDPAGE_PASSPHRASE or the output of command, or with keychain a
+// random key kept in the OS keychain.
+type keySource struct {
+	keychain bool
+	command  string
+}
+
+func (k keySource) kdf() byte {
+	if k.keychain {
+		return kdfNone
+	}
+	return kdfPBKDF2
+}
+
+// secret returns the passphrase or key.
+func (k keySource) secret(create bool) ([]byte, error) {
+	if k.keychain {
+		return keychainKey(create)
+	}
+
+	if p := os.Getenv("RANDPAGE_PASSPHRASE"); p != "" {
+		return []byte(p), nil
+	}
+	if k.command == "" {
+		return nil, errors.New("the state is encrypted with a passphrase: set RANDPAGE_PASSPHRASE or passphrase_command")
+	}
+	args, err := splitCommand(k.command)
+	if err != nil {
+		return nil, fmt.Errorf("passphrase_command: %w", err)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stderr = os.Stdin, os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("passphrase_command: %w", err)
+	}
+	p := strings.TrimRight(string(out), "\r\n")
+	if p == "" {
+		return nil, errors.New("passphrase_command printed no passphrase")
+	}
+	return []byte(p), nil
+}
+
+// The state's key is kept in the keychain under this service and
+// account.
+const (
+	keychainService = "randpage"
+	keychainAccount = "state"
+)
+
+// keychainKey returns the state's key from the macOS Keychain or, on
+// Linux, the Secret Service (GNOME Keyring or KWallet), making one if
+// there isn't one yet and create is set.
+func keychainKey(create bool) ([]byte, error) {
+	var lookup func() *exec.Cmd
+	var store func(hexKey string) *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		lookup = func() *exec.Cmd {
+			return exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+		}
+		store = func(hexKey string) *exec.Cmd {
+			// The command is read from stdin, so the key isn't in the
+			// arguments for ps to show.
+			cmd := exec.Command("security", "-i")
+			cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -s %s -a %s -w %s\n", keychainService, keychainAccount, hexKey))
+			return cmd
+		}
+	case "linux", "freebsd", "openbsd":
+		lookup = func() *exec.Cmd {
+			return exec.Command("secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+		}
+		store = func(hexKey string) *exec.Cmd {
+			cmd := exec.Command("secret-tool", "store", "--label", "randpage state key", "service", keychainService, "account", keychainAccount)
+			cmd.Stdin = strings.NewReader(hexKey)
+			return cmd
+		}
+	default:
+		return nil, fmt.Errorf("no keychain support on %s: encrypt with a passphrase instead", runtime.GOOS)
+	}
+
+	out, err := lookup().Output()
+	if hexKey := strings.TrimSpace(string(out)); err == nil && hexKey != "" {
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("the key in the keychain: %w", err)
+		}
+		return key, nil
+	}
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return nil, fmt.Errorf("keychain: %w", err)
+	}
+	if !create {
+		return nil, errors.New("keychain: there's no key for the state")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	hexKey := hex.EncodeToString(key)
+	cmd := store(hexKey)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("keychain: storing the key: %w", err)
+	}
+
+	// Make sure it's there, since nothing could read the state without it.
+	if out, err := lookup().Output(); err != nil || strings.TrimSpace(string(out)) != hexKey {
+		return nil, errors.New("keychain: the new key wasn't stored")
+	}
+	return key, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+// epubLinger is how long the epub server keeps running after the chosen
+// chapter has been sent, so the browser can fetch its stylesheets and
+// images.
+const epubLinger = 5 * time.Second
+
+// epubFormat treats each linear spine item (roughly, a chapter) as a page.
+type epubFormat struct{}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Manifest []struct {
+		ID   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IDRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// epubSpine returns the archive paths of the book's linear spine items, in
+// reading order.
+func epubSpine(zr *zip.Reader) ([]string, error) {
+	var container epubContainer
+	if err := readZipXML(zr, "META-INF/container.xml", &container); err != nil {
+		return nil, err
+	}
+	if len(container.Rootfiles) == 0 {
+		return nil, errors.New("epub has no rootfile")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg epubPackage
+	if err := readZipXML(zr, opfPath, &pkg); err != nil {
+		return nil, err
+	}
+
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.ID] = item.Href
+	}
+
+	var ret []string
+	for _, ref := range pkg.Spine {
+		if ref.Linear == "no" {
+			continue
+		}
+		href, ok := hrefs[ref.IDRef]
+		if !ok {
+			continue
+		}
+		// Hrefs are urls, so "Chapter%201.xhtml" is stored as
+		// "Chapter 1.xhtml".
+		if name, err := url.PathUnescape(href); err == nil {
+			href = name
+		}
+		ret = append(ret, path.Join(path.Dir(opfPath), href))
+	}
+
+	return ret, nil
+}
+
+func readZipXML(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return xml.NewDecoder(f).Decode(v)
+}
+
+func (epubFormat) countPages(path string) (int, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	spine, err := epubSpine(&zr.Reader)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(spine), nil
+}
+
+// open serves the whole book from a temporary web server and points the
+// browser at the chosen spine item, so relative links to stylesheets and
+// images keep working. The book names its own chapters, so name is unused.
+func (epubFormat) open(path, name string, page int) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	spine, err := epubSpine(&zr.Reader)
+	if err != nil {
+		return err
+	}
+	if page < 1 || page > len(spine) {
+		return fmt.Errorf("epub has no spine item %d", page)
+	}
+	chapter := spine[page-1]
+
+	done := make(chan struct{})
+	var once sync.Once
+	files := http.FileServer(http.FS(zr))
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		files.ServeHTTP(w, r)
+
+		if r.URL.Path == "/"+chapter {
+			once.Do(func() {
+				time.AfterFunc(epubLinger, func() { close(done) })
+			})
+		}
+	})
+
+	return serve(handler, chapter, "", done)
+}
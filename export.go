@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An entry in the reading log is a pick and the notes on its page, or
+// notes whose pick has left the history.
+type logEntry struct {
+	doc   candidate
+	page  int
+	time  time.Time
+	how   string
+	notes []note
+}
+
+// exportCommand writes the reading log for another tool: randpage export
+// [-format md|readwise|csv] [-since age|date].
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "md", "write the log as `format`: md, readwise, or csv")
+	var since sinceTime
+	fs.Var(&since, "since", "only export picks from the last `age` (like 7d) or since a date (like 2024-05-01)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: randpage export [-format md|readwise|csv] [-since age|date]")
+	}
+
+	write, ok := logWriters[*format]
+	if !ok {
+		return fmt.Errorf("export: unknown format %q (want md, readwise, or csv)", *format)
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	var entries []logEntry
+	for _, e := range readingLog(st) {
+		if !e.time.Before(time.Time(since)) {
+			entries = append(entries, e)
+		}
+	}
+	return write(os.Stdout, entries)
+}
+
+var logWriters = map[string]func(w io.Writer, entries []logEntry) error{
+	"md":       writeMarkdownLog,
+	"readwise": writeReadwiseLog,
+	"csv":      writeCSVLog,
+}
+
+// readingLog returns st's picks, oldest first, each with the notes written
+// on its page before the next pick of that page.
+func readingLog(st *state) []logEntry {
+	type pageKey struct {
+		key  string
+		page int
+	}
+
+	entries := make([]logEntry, len(st.History))
+	latest := make(map[pageKey][]int)
+	for i, p := range st.History {
+		entries[i] = logEntry{doc: p.Doc, page: p.Page, time: p.Time, how: p.How}
+		k := pageKey{p.Doc.key(), p.Page}
+		latest[k] = append(latest[k], i)
+	}
+
+	for _, n := range st.Notes {
+		// The note goes with the last pick of its page before it.
+		picks := latest[pageKey{n.Doc.key(), n.Page}]
+		j := sort.Search(len(picks), func(j int) bool { return entries[picks[j]].time.After(n.Time) }) - 1
+		if j < 0 {
+			entries = append(entries, logEntry{doc: n.Doc, page: n.Page, time: n.Time, how: "note", notes: []note{n}})
+			continue
+		}
+		e := &entries[picks[j]]
+		e.notes = append(e.notes, n)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].time.Before(entries[j].time) })
+	return entries
+}
+
+// docTitle is what to call doc in a log: its title or file name.
+func docTitle(doc candidate) string {
+	if doc.Title != "" {
+		return doc.Title
+	}
+	return doc.name()
+}
+
+// docURL returns a link to doc.
+func docURL(doc candidate) string {
+	if doc.remote() {
+		return doc.Path
+	}
+	return fileURL(doc.abs().Path)
+}
+
+// fileURL returns the file url for the absolute path p.
+func fileURL(p string) string {
+	p = filepath.ToSlash(p)
+	if !strings.HasPrefix(p, "/") {
+		// A Windows path, like C:/Users.
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// writeMarkdownLog writes entries as a list for each day, with the notes
+// under their pages.
+func writeMarkdownLog(w io.Writer, entries []logEntry) error {
+	fmt.Fprintf(w, "# Reading log\n")
+	day := ""
+	for _, e := range entries {
+		if d := e.time.Local().Format(time.DateOnly); d != day {
+			fmt.Fprintf(w, "\n## %s\n\n", d)
+			day = d
+		}
+		fmt.Fprintf(w, "- p. %d of [%s](<%s>)", e.page, docTitle(e.doc), docURL(e.doc))
+		if len(e.doc.Authors) > 0 {
+			fmt.Fprintf(w, " by %s", strings.Join(e.doc.Authors, ", "))
+		}
+		fmt.Fprintln(w)
+		for _, n := range e.notes {
+			fmt.Fprintf(w, "  - %s\n", strings.ReplaceAll(n.Text, "\n", "\n    "))
+		}
+	}
+	return nil
+}
+
+// writeReadwiseLog writes the notes as a CSV file for Readwise's import,
+// with each note as a highlight. Picks without notes are left out, since
+// Readwise has nothing to show for them.
+func writeReadwiseLog(w io.Writer, entries []logEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"})
+	for _, e := range entries {
+		for _, n := range e.notes {
+			cw.Write([]string{
+				n.Text,
+				docTitle(e.doc),
+				strings.Join(e.doc.Authors, ", "),
+				docURL(e.doc),
+				"",
+				fmt.Sprint(e.page),
+				n.Time.UTC().Format(time.DateTime),
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSVLog writes a row for each entry, with its notes on separate
+// lines of the last column.
+func writeCSVLog(w io.Writer, entries []logEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "document", "title", "page", "how", "notes"})
+	for _, e := range entries {
+		var notes []string
+		for _, n := range e.notes {
+			notes = append(notes, n.Text)
+		}
+		cw.Write([]string{
+			e.time.Format(time.RFC3339),
+			e.doc.String(),
+			docTitle(e.doc),
+			fmt.Sprint(e.page),
+			e.how,
+			strings.Join(notes, "\n"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
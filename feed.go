@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// feedSource lists the pdf links in an RSS or Atom feed, like a journal's
+// table of contents: feed:https://example.org/rss. Entries are remembered
+// in the cache directory, so papers stay candidates after they fall off
+// the end of the feed, and while the feed is unreachable.
+type feedSource struct {
+	u *url.URL
+	w *walker
+}
+
+func newFeedSource(arg string, w *walker) (source, error) {
+	u, err := url.Parse(strings.TrimPrefix(arg, "feed:"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s: feeds need an http or https url", arg)
+	}
+	return feedSource{u: u, w: w}, nil
+}
+
+// A feedEntry is a document linked from a feed.
+type feedEntry struct {
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Published time.Time `json:"published"`
+}
+
+// feedXML covers RSS 2.0, RSS 1.0 (whose items are outside the channel),
+// and Atom.
+type feedXML struct {
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title      string `xml:"title"`
+	Link       string `xml:"link"`
+	PubDate    string `xml:"pubDate"`
+	Date       string `xml:"date"`
+	Enclosures []struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+}
+
+type atomEntry struct {
+	Title     string `xml:"title"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Links     []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+}
+
+var feedTimeLayouts = []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "2006-01-02"}
+
+func (s feedSource) list() ([]candidate, error) {
+	entries, err := s.load()
+	if err != nil {
+		slog.Info("reading feed cache", "feed", s.u, "err", err)
+	}
+
+	fresh, err := s.poll()
+	if err != nil {
+		if len(entries) == 0 {
+			return nil, err
+		}
+		slog.Info("polling feed", "feed", s.u, "err", err)
+	} else {
+		entries = mergeEntries(entries, fresh)
+		if err := s.save(entries); err != nil {
+			slog.Info("saving feed cache", "feed", s.u, "err", err)
+		}
+	}
+
+	var ret []candidate
+	for _, e := range entries {
+		if s.w.skipRemote("", e.URL, false) {
+			continue
+		}
+		ret = append(ret, candidate{Path: e.URL, Title: e.Title, ModTime: e.Published})
+	}
+	return ret, nil
+}
+
+func (s feedSource) fetch(c candidate, w io.Writer) error {
+	return httpSource{}.fetch(c, w)
+}
+
+// poll fetches the feed and returns its entries that link to documents.
+func (s feedSource) poll() ([]feedEntry, error) {
+	req, err := http.NewRequest("GET", s.u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doHTTP(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var doc feedXML
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.u, err)
+	}
+
+	var ret []feedEntry
+	add := func(link, title string, published time.Time) {
+		ref, err := url.Parse(strings.TrimSpace(link))
+		if err != nil || link == "" {
+			return
+		}
+		ret = append(ret, feedEntry{URL: s.u.ResolveReference(ref).String(), Title: strings.TrimSpace(title), Published: published})
+	}
+
+	for _, item := range append(doc.Channel.Items, doc.Items...) {
+		published := parseFeedTime(item.PubDate, item.Date)
+		if link := item.documentLink(); link != "" {
+			add(link, item.Title, published)
+		}
+	}
+	for _, entry := range doc.Entries {
+		published := parseFeedTime(entry.Published, entry.Updated)
+		if link := entry.documentLink(); link != "" {
+			add(link, entry.Title, published)
+		}
+	}
+
+	return ret, nil
+}
+
+// documentLink returns the item's pdf enclosure, or its link if that's a
+// document.
+func (item rssItem) documentLink() string {
+	for _, enc := range item.Enclosures {
+		if enc.Type == "application/pdf" || looksLikeDocument(path.Base(enc.URL)) {
+			return enc.URL
+		}
+	}
+	if looksLikeDocument(urlName(strings.TrimSpace(item.Link))) {
+		return item.Link
+	}
+	return ""
+}
+
+// documentLink returns the entry's pdf link, if it has one.
+func (entry atomEntry) documentLink() string {
+	for _, link := range entry.Links {
+		if link.Type == "application/pdf" || looksLikeDocument(urlName(link.Href)) {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+func parseFeedTime(values ...string) time.Time {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		for _, layout := range feedTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// mergeEntries adds the fresh entries to the remembered ones, updating
+// any that were already known.
+func mergeEntries(old, fresh []feedEntry) []feedEntry {
+	index := make(map[string]int)
+	for i, e := range old {
+		index[e.URL] = i
+	}
+
+	for _, e := range fresh {
+		if i, ok := index[e.URL]; ok {
+			old[i] = e
+			continue
+		}
+		index[e.URL] = len(old)
+		old = append(old, e)
+	}
+
+	return old
+}
+
+// cachePath is where the feed's entries are remembered.
+func (s feedSource) cachePath() string {
+	sum := sha256.Sum256([]byte(s.u.String()))
+	return filepath.Join(cacheDir(), "feeds", hex.EncodeToString(sum[:8])+".json")
+}
+
+func (s feedSource) load() ([]feedEntry, error) {
+	buf, err := os.ReadFile(s.cachePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []feedEntry
+	if err := json.Unmarshal(buf, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s feedSource) save(entries []feedEntry) error {
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.cachePath()), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.cachePath(), bytes.NewReader(buf))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A format knows how to count and open one kind of document. A "page" is
+// whatever unit the format can jump to: a real page for pdf, a spine item
+// for epub.
+type format interface {
+	countPages(path string) (int, error)
+
+	// open opens the document at path to page, which is 1-indexed. name
+	// is what to call the document in the viewer, where that's possible.
+	open(path, name string, page int) error
+}
+
+// A sectioner is a format that can list where a document's chapters and
+// sections start, from its outline.
+type sectioner interface {
+	sections(path string) ([]int, error)
+
+	// chapters lists where just the chapters start.
+	chapters(path string) ([]int, error)
+}
+
+// An extractor is a format that can write a run of pages to a pdf of
+// their own.
+type extractor interface {
+	extract(path, out string, first, last int) error
+}
+
+// A spreader is a format that can open a run of pages together, as a
+// document of their own.
+type spreader interface {
+	openSpread(path, name string, first, last int) error
+}
+
+// formats maps lowercase file extensions to the format that handles them.
+var formats = map[string]format{
+	".pdf":  pdfFormat{},
+	".epub": epubFormat{},
+	".djvu": djvuFormat{},
+	".djv":  djvuFormat{},
+	".cbz":  comicFormat{},
+	".cbr":  comicFormat{},
+}
+
+// formatFor returns the format for a file name, or nil if it isn't a
+// document randpage knows how to open.
+func formatFor(name string) format {
+	return formats[strings.ToLower(filepath.Ext(name))]
+}
+
+func looksLikeDocument(s string) bool {
+	return formatFor(s) != nil
+}
+
+// sniffable lists the formats sniffFormat can recognize, by extension.
+var sniffable = map[string]bool{".pdf": true, ".epub": true, ".djvu": true, ".djv": true}
+
+// sniffFormat identifies a document by its leading bytes, returning the
+// extension its format is registered under, or "" if it isn't a document
+// sniffFormat recognizes.
+func sniffFormat(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	switch {
+	// Readers accept junk before the header, so look a little way in.
+	case bytes.Contains(head, []byte("%PDF-")):
+		return ".pdf"
+	case bytes.HasPrefix(head, []byte("AT&TFORM")):
+		return ".djvu"
+	// An epub's first zip entry is an uncompressed "mimetype" file.
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) && bytes.HasPrefix(head[min(30, n):], []byte("mimetypeapplication/epub+zip")):
+		return ".epub"
+	}
+
+	return ""
+}
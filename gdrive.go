@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// gdriveSource lists a Google Drive folder, named by its id (the last part
+// of the folder's url): gdrive:1AbC.... Requests are authorized with an
+// OAuth access token from RANDPAGE_GDRIVE_TOKEN, or for publicly shared
+// folders, an API key from RANDPAGE_GDRIVE_KEY. Either can also be set in
+// the config file.
+//
+// Candidates are named gdrive:<file id>/<file name>; only the id matters
+// for fetching, but the name says what the file is.
+type gdriveSource struct {
+	folder string
+	w      *walker
+}
+
+const gdriveFolderType = "application/vnd.google-apps.folder"
+
+func newGDriveSource(arg string, w *walker) (source, error) {
+	folder := strings.Trim(strings.TrimPrefix(arg, "gdrive:"), "/")
+	if folder == "" {
+		return nil, errors.New("gdrive: missing folder id")
+	}
+	return gdriveSource{folder: folder, w: w}, nil
+}
+
+type gdriveFileList struct {
+	Files []struct {
+		ID       string    `json:"id"`
+		Name     string    `json:"name"`
+		MimeType string    `json:"mimeType"`
+		Size     int64     `json:"size,string"`
+		Modified time.Time `json:"modifiedTime"`
+	} `json:"files"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (s gdriveSource) list() ([]candidate, error) {
+	var ret []candidate
+
+	// Drive has no paths, so build one from folder names for the walker's
+	// exclude patterns.
+	var visit func(id, dir string, depth int) error
+	visit = func(id, dir string, depth int) error {
+		query := url.Values{
+			"q":                         {"'" + id + "' in parents and trashed = false"},
+			"fields":                    {"nextPageToken,files(id,name,mimeType,size,modifiedTime)"},
+			"pageSize":                  {"1000"},
+			"supportsAllDrives":         {"true"},
+			"includeItemsFromAllDrives": {"true"},
+		}
+
+		for {
+			var list gdriveFileList
+			if err := gdriveGet("https://www.googleapis.com/drive/v3/files", query, &list); err != nil {
+				return err
+			}
+
+			for _, f := range list.Files {
+				name := strings.ReplaceAll(f.Name, "/", "_")
+				p := dir + "/" + name
+				isDir := f.MimeType == gdriveFolderType
+
+				if s.w.skipRemote("/", p, isDir) {
+					continue
+				}
+
+				if isDir {
+					if s.w.maxDepth == 0 || depth+1 < s.w.maxDepth {
+						if err := visit(f.ID, p, depth+1); err != nil {
+							slog.Info("listing drive folder", "folder", p, "err", err)
+						}
+					}
+					continue
+				}
+
+				if !looksLikeDocument(name) && f.MimeType == "application/pdf" {
+					name += ".pdf"
+				}
+				if looksLikeDocument(name) {
+					ret = append(ret, candidate{Path: "gdrive:" + f.ID + "/" + name, Size: f.Size, ModTime: f.Modified})
+				}
+			}
+
+			if list.NextPageToken == "" {
+				return nil
+			}
+			query.Set("pageToken", list.NextPageToken)
+		}
+	}
+
+	if err := visit(s.folder, "", 0); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s gdriveSource) fetch(c candidate, w io.Writer) error {
+	id, _, _ := strings.Cut(strings.TrimPrefix(c.Path, "gdrive:"), "/")
+
+	resp, err := gdriveRequest("https://www.googleapis.com/drive/v3/files/"+url.PathEscape(id),
+		url.Values{"alt": {"media"}, "supportsAllDrives": {"true"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func gdriveGet(endpoint string, query url.Values, v any) error {
+	resp, err := gdriveRequest(endpoint, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func gdriveRequest(endpoint string, query url.Values) (*http.Response, error) {
+	token := envOr("RANDPAGE_GDRIVE_TOKEN", cfg.GDrive.Token)
+	key := envOr("RANDPAGE_GDRIVE_KEY", cfg.GDrive.Key)
+	if token == "" && key == "" {
+		return nil, errors.New("gdrive: set RANDPAGE_GDRIVE_TOKEN or RANDPAGE_GDRIVE_KEY, or [gdrive] token or key in the config file")
+	}
+
+	if token == "" {
+		query.Set("key", key)
+	}
+
+	req, err := http.NewRequest("GET", endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return doHTTP(req)
+}
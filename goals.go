@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+// A goalConfig is a reading goal from the config: Pages picks each Per
+// (day, week, or month), or to Finish the document whose path contains
+// some text By a date, like 2024-06-30 or, for the end of the month,
+// 2024-06.
+type goalConfig struct {
+	Pages  int    `toml:"pages"`
+	Per    string `toml:"per"`
+	Finish string `toml:"finish"`
+	By     string `toml:"by"`
+}
+
+// A goal is a parsed goalConfig.
+type goal struct {
+	pages  int
+	per    string
+	finish string
+	by     time.Time // the end of the day it's due
+}
+
+// goals are the reading goals from the config.
+var goals []goal
+
+// The periods goals can be per.
+const (
+	perDay   = "day"
+	perWeek  = "week"
+	perMonth = "month"
+)
+
+// newGoals parses the config's goals.
+func newGoals(configs []goalConfig) ([]goal, error) {
+	var ret []goal
+	for _, c := range configs {
+		switch {
+		case c.Pages > 0 && c.Finish == "":
+			g := goal{pages: c.Pages, per: c.Per}
+			if g.per == "" {
+				g.per = perWeek
+			}
+			if g.per != perDay && g.per != perWeek && g.per != perMonth {
+				return nil, fmt.Errorf("unknown period %q (want day, week, or month)", c.Per)
+			}
+			ret = append(ret, g)
+		case c.Finish != "" && c.Pages == 0:
+			by, err := parseDue(c.By)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, goal{finish: c.Finish, by: by})
+		default:
+			return nil, fmt.Errorf("a goal needs pages or finish, but not both")
+		}
+	}
+	return ret, nil
+}
+
+// parseDue returns the end of the day s names, or of the month.
+func parseDue(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.ParseInLocation("2006-01", s, time.Local); err == nil {
+		return t.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (want a date like 2024-06-30, or a month like 2024-06)", s)
+}
+
+// progress describes how g is going at now, given st and the library
+// docs.
+func (g goal) progress(st *state, docs []candidate, pc *pageCounts, now time.Time) string {
+	if g.finish == "" {
+		return g.pagesProgress(st, now)
+	}
+	return g.finishProgress(st, docs, pc, now)
+}
+
+func (g goal) pagesProgress(st *state, now time.Time) string {
+	var start, end time.Time
+	switch g.per {
+	case perDay:
+		start = midnight(now)
+		end = start.AddDate(0, 0, 1)
+	case perWeek:
+		start = startOfWeek(now)
+		end = start.AddDate(0, 0, 7)
+	case perMonth:
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		end = start.AddDate(0, 1, 0)
+	}
+
+	n := 0
+	for _, p := range st.History {
+		if !p.Time.Before(start) && (!*streakRead || p.Read) {
+			n++
+		}
+	}
+
+	period := "this " + g.per
+	if g.per == perDay {
+		period = "today"
+	}
+	ret := fmt.Sprintf("%d of %d pages %s%s", n, g.pages, period, percent(min(n, g.pages), g.pages))
+	if n >= g.pages {
+		return ret + ", met"
+	}
+	return ret + fmt.Sprintf(", %d to go %s", g.pages-n, timeLeft(end.Sub(now)))
+}
+
+func (g goal) finishProgress(st *state, docs []candidate, pc *pageCounts, now time.Time) string {
+	doc, ok := findGoalDoc(g.finish, st, docs)
+	if !ok {
+		return fmt.Sprintf("finish %s: no such document", g.finish)
+	}
+	name := docTitle(doc)
+	if _, done := st.Done[doc.key()]; done {
+		return fmt.Sprintf("finish %s: done", name)
+	}
+
+	due := g.by.AddDate(0, 0, -1).Format(time.DateOnly)
+	seen := len(st.Seen[doc.key()])
+	pages, err := pc.count(statLocal(doc), *fileTimeout)
+	if err != nil || pages == 0 {
+		return fmt.Sprintf("finish %s by %s: %d pages seen", name, due, seen)
+	}
+
+	seen = min(seen, pages)
+	ret := fmt.Sprintf("finish %s by %s: %d of %d pages%s", name, due, seen, pages, percent(seen, pages))
+	left := g.by.Sub(now)
+	if left <= 0 {
+		return ret + ", overdue"
+	}
+	perDay := math.Ceil(float64(pages-seen) / math.Ceil(left.Hours()/24))
+	return ret + fmt.Sprintf(", %d to go %s, about %.0f a day", pages-seen, timeLeft(left), perDay)
+}
+
+// findGoalDoc returns the document whose path contains text, from the
+// library or else the history.
+func findGoalDoc(text string, st *state, docs []candidate) (candidate, bool) {
+	for _, doc := range docs {
+		if strings.Contains(doc.String(), text) {
+			return doc, true
+		}
+	}
+	for i := len(st.History) - 1; i >= 0; i-- {
+		if doc := st.History[i].Doc; strings.Contains(doc.String(), text) {
+			return doc, true
+		}
+	}
+	return candidate{}, false
+}
+
+// timeLeft returns d in words, like "in 3 days".
+func timeLeft(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("in %s", roughly(d))
+	}
+	return fmt.Sprintf("in %s", days(int(math.Ceil(d.Hours()/24))))
+}
+
+// goalsShown is whether this run has logged the goals yet.
+var goalsShown bool
+
+// showGoals logs how the goals are going once a run, after its first
+// pick.
+func showGoals(st *state) {
+	if goalsShown || len(goals) == 0 {
+		return
+	}
+	goalsShown = true
+
+	// Without a scan, the goals' documents are found in the history.
+	docs, _ := loadLastScan()
+	pc := loadPageCounts()
+	defer pc.save()
+	for _, g := range goals {
+		slog.Info("goal", "progress", g.progress(st, docs, pc, time.Now()))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyCommand lists past picks, oldest first: randpage history [-json]
+// [-since age|date] [-file text]. -file keeps the picks of documents whose
+// names contain text.
+func historyCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the picks as JSON")
+	var since sinceTime
+	fs.Var(&since, "since", "only list picks from the last `age` (like 7d) or since a date (like 2024-05-01)")
+	file := fs.String("file", "", "only list picks of documents whose names contain `text`")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: randpage history [-json] [-since age|date] [-file text]")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	picks := []pick{}
+	for _, p := range st.History {
+		if p.Time.Before(time.Time(since)) {
+			continue
+		}
+		if *file != "" && !strings.Contains(p.Doc.String(), *file) {
+			continue
+		}
+		picks = append(picks, p)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(picks)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range picks {
+		how := p.How
+		if how == "" {
+			how = "-"
+		}
+		fmt.Fprintf(out, "%s\t%s\tp. %d\t%s\n", p.Time.Local().Format("2006-01-02 15:04"), how, p.Page, p.Doc)
+	}
+	return out.Flush()
+}
+
+// sinceTime is a flag.Value for the start of a span of time: an age
+// before now or a date.
+type sinceTime time.Time
+
+func (s *sinceTime) String() string {
+	if time.Time(*s).IsZero() {
+		return ""
+	}
+	return time.Time(*s).Format(time.DateOnly)
+}
+
+func (s *sinceTime) Set(v string) error {
+	if t, err := time.ParseInLocation(time.DateOnly, v, time.Local); err == nil {
+		*s = sinceTime(t)
+		return nil
+	}
+
+	var a age
+	if err := a.Set(v); err != nil {
+		return fmt.Errorf("invalid time %q: want an age like 7d or a date like 2024-05-01", v)
+	}
+	*s = sinceTime(a.cutoff())
+	return nil
+}
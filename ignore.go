@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFile is the name of the gitignore-style file randpage reads from
+// each library root and from its config directory.
+const ignoreFile = ".randpageignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// An ignoreList is a parsed ignore file. As with gitignore, later rules
+// take precedence over earlier ones.
+type ignoreList []ignoreRule
+
+// loadIgnores returns the ignore rules that apply under root: the global
+// file in the config directory, then the root's own.
+func loadIgnores(root string) ignoreList {
+	var ret ignoreList
+
+	for _, path := range []string{filepath.Join(configDir(), ignoreFile), filepath.Join(root, ignoreFile)} {
+		rules, err := readIgnoreFile(path)
+		if err != nil {
+			slog.Info("reading ignore file", "path", path, "err", err)
+			continue
+		}
+		ret = append(ret, rules...)
+	}
+
+	return ret
+}
+
+// readIgnoreFile parses the ignore file at path. A missing file has no
+// rules.
+func readIgnoreFile(path string) (ignoreList, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseIgnore(f)
+}
+
+func parseIgnore(r io.Reader) (ignoreList, error) {
+	var ret ignoreList
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		var err error
+		if rule.re, rule.dirOnly, err = compilePattern(line); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, rule)
+	}
+
+	return ret, scanner.Err()
+}
+
+// compilePattern compiles a gitignore-style pattern, reporting whether it
+// only matches directories (with a trailing slash). A pattern with a slash
+// in it is relative to the root; one without matches at any depth.
+func compilePattern(pattern string) (*regexp.Regexp, bool, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		pattern = "**/" + pattern
+	}
+
+	re, err := regexp.Compile(globToRegexp(pattern))
+	return re, dirOnly, err
+}
+
+// globToRegexp translates a gitignore glob to an anchored regular
+// expression. "*" and "?" don't cross directories; "**" does.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
+// ignored reports whether rel, a slash-separated path relative to the
+// root, is ignored.
+func (l ignoreList) ignored(rel string, isDir bool) bool {
+	ret := false
+	for _, rule := range l {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ret = !rule.negate
+		}
+	}
+	return ret
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	tests := []struct {
+		rules string
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"*.tmp", "a.tmp", false, true},
+		{"*.tmp", "x/y/a.tmp", false, true},
+		{"*.tmp", "a.tmpx", false, false},
+		{"/drafts", "drafts", true, true},
+		{"/drafts", "x/drafts", true, false},
+		{"old/scans", "old/scans", true, true},
+		{"old/scans", "x/old/scans", true, false},
+		{"build/", "build", true, true},
+		{"build/", "x/build", true, true},
+		{"build/", "build", false, false},
+		{"*.pdf\n!keep.pdf", "a.pdf", false, true},
+		{"*.pdf\n!keep.pdf", "x/keep.pdf", false, false},
+		{"!keep.pdf\n*.pdf", "keep.pdf", false, true},
+		{"docs/**/old", "docs/old", true, true},
+		{"docs/**/old", "docs/a/b/old", true, true},
+		{"docs/**", "docs/a/b.pdf", false, true},
+		{"a*.pdf", "a/b.pdf", false, false},
+		{"?.pdf", "a.pdf", false, true},
+		{"?.pdf", "ab.pdf", false, false},
+		{"[ab].pdf", "b.pdf", false, true},
+		{"[ab].pdf", "c.pdf", false, false},
+		{"[!ab].pdf", "c.pdf", false, true},
+		{"[ab.pdf", "[ab.pdf", false, true},
+		{`\#notes.pdf`, "#notes.pdf", false, true},
+		{"# a comment\n\n*.tmp  ", "a.tmp", false, true},
+		{"# *.pdf", "a.pdf", false, false},
+		{"a.pdf", "a_pdf", false, false},
+	}
+
+	for _, tt := range tests {
+		rules, err := parseIgnore(strings.NewReader(tt.rules))
+		if err != nil {
+			t.Errorf("parseIgnore(%q): %v", tt.rules, err)
+			continue
+		}
+		if got := rules.ignored(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("rules %q: ignored(%q, %v) = %v, want %v", tt.rules, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
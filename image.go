@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A renderer is a format that can draw a page as an image.
+type renderer interface {
+	// render writes page of the document at path to out as a png, at
+	// dpi dots per inch.
+	render(path string, page, dpi int, out string) error
+}
+
+// imageDPI is the resolution pages are drawn at for the image viewer:
+// enough to read, and a long way from the size of a page scanned at 600.
+const imageDPI = 150
+
+// renderKeep is how long rendered pages are kept for the image viewer,
+// which may only read them after randpage has gone.
+const renderKeep = 24 * time.Hour
+
+// render draws a pdf page with mutool, from MuPDF, or else pdftoppm,
+// from Poppler.
+func (pdfFormat) render(path string, page, dpi int, out string) error {
+	var cmd *exec.Cmd
+	if _, err := exec.LookPath("mutool"); err == nil {
+		cmd = exec.Command("mutool", "draw", "-q", "-r", strconv.Itoa(dpi), "-o", out, path, strconv.Itoa(page))
+	} else if _, err := exec.LookPath("pdftoppm"); err == nil {
+		p := strconv.Itoa(page)
+		cmd = exec.Command("pdftoppm", "-png", "-r", strconv.Itoa(dpi), "-f", p, "-l", p, "-singlefile", path, strings.TrimSuffix(out, ".png"))
+	} else {
+		return errors.New("drawing pdf pages needs mutool (from MuPDF) or pdftoppm (from Poppler)")
+	}
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", cmd.Args[0], err, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// render draws a djvu page by way of a one-page pdf.
+func (djvuFormat) render(path string, page, dpi int, out string) error {
+	pdf := strings.TrimSuffix(out, ".png") + ".pdf"
+	defer os.Remove(pdf)
+
+	cmd := exec.Command("ddjvu", "-format=pdf", "-page="+strconv.Itoa(page), path, pdf)
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("ddjvu: %w: %s", err, strings.TrimSpace(string(msg)))
+	}
+	return pdfFormat{}.render(pdf, 1, dpi, out)
+}
+
+// openImage draws page of the document at path with r and opens the
+// image in the image viewer. The images are kept in the cache for a
+// while, since the viewer may not have read one by the time it returns.
+func openImage(r renderer, path, name string, page int) error {
+	dir := filepath.Join(cacheDir(), "pages")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	clearRendered(dir)
+
+	out := filepath.Join(dir, fmt.Sprintf("%s p%d.png", strings.TrimSuffix(name, filepath.Ext(name)), page))
+	if err := r.render(path, page, imageDPI, out); err != nil {
+		return err
+	}
+
+	args, detach := defaultViewer()
+	args = append(args, out)
+	if cfg.ImageViewer != "" {
+		words, err := splitCommand(cfg.ImageViewer)
+		if err != nil {
+			return fmt.Errorf("image_viewer: %w", err)
+		}
+		args, detach = fillTemplate(words, fileURL(out), out, page), false
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	if !detach {
+		if err := cmd.Run(); err != nil {
+			slog.Error("executing image viewer", "path", out, "err", err)
+			return viewerError(err)
+		}
+		return nil
+	}
+	if err := cmd.Start(); err != nil {
+		slog.Error("executing image viewer", "path", out, "err", err)
+		return viewerError(err)
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// clearRendered removes the images in dir drawn longer than renderKeep
+// ago.
+func clearRendered(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > renderKeep {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// An index remembers what a walk found in each directory, so the next walk
+// of the same root only reads the directories that changed. A directory's
+// modification time changes when entries are added, removed, or renamed
+// in it, but not when a file in it is rewritten in place; --rescan reads
+// everything again.
+//
+// Documents' page counts are kept separately, in pageCounts, keyed by the
+// size and modification time recorded here.
+type index struct {
+	path string
+
+	// old is what the last walk found; new is what this one has, so
+	// directories that are gone drop out when it's saved.
+	old map[string]indexDir
+
+	mu  sync.Mutex
+	new map[string]indexDir
+}
+
+// An indexDir is what was found directly in a directory.
+type indexDir struct {
+	ModTime time.Time   `json:"mtime"`
+	Docs    []candidate `json:"docs,omitempty"`
+
+	// Dirs are the subdirectories to walk, including symlinks to them.
+	Dirs []string `json:"dirs,omitempty"`
+}
+
+// loadIndex returns the index for walking root with w's options. Walks
+// with different options have their own indexes. If rescan is set, the
+// index starts out empty.
+func loadIndex(w *walker, root string, ignores ignoreList, rescan bool) *index {
+	idx := &index{
+		path: filepath.Join(cacheDir(), "index", indexKey(w, root, ignores)+".json"),
+		old:  make(map[string]indexDir),
+		new:  make(map[string]indexDir),
+	}
+	if rescan {
+		return idx
+	}
+
+	buf, err := os.ReadFile(idx.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Info("reading index", "path", idx.path, "err", err)
+		}
+		return idx
+	}
+	if err := json.Unmarshal(buf, &idx.old); err != nil {
+		slog.Info("reading index", "path", idx.path, "err", err)
+	}
+
+	return idx
+}
+
+// indexKey names the index for a walk: everything that changes what the
+// walk would find goes into it.
+func indexKey(w *walker, root string, ignores ignoreList) string {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %v %v %q %d %q\n", root, w.followSymlinks, w.includeHidden, w.sniff, w.maxDepth, w.excludes)
+	for _, rule := range ignores {
+		fmt.Fprintf(h, "%q %v %v\n", rule.re, rule.negate, rule.dirOnly)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// lookup returns what was found in dir last time, if it hasn't changed
+// since, and keeps it for this walk's index.
+func (idx *index) lookup(dir string, modTime time.Time) (indexDir, bool) {
+	d, ok := idx.old[dir]
+	if !ok || !d.ModTime.Equal(modTime) {
+		return indexDir{}, false
+	}
+
+	idx.put(dir, d)
+	return d, true
+}
+
+func (idx *index) put(dir string, d indexDir) {
+	idx.mu.Lock()
+	idx.new[dir] = d
+	idx.mu.Unlock()
+}
+
+func (idx *index) save() error {
+	buf, err := json.Marshal(idx.new)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(idx.path, bytes.NewReader(buf))
+}
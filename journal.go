@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultDateFormat is how daily notes are named unless the config says
+// otherwise, as Obsidian names them.
+const defaultDateFormat = "2006-01-02"
+
+// dailyNotePath returns the daily note for t from the config's pattern,
+// where {date} stands for the date in the config's date format.
+func dailyNotePath(t time.Time) string {
+	layout := cfg.Obsidian.DateFormat
+	if layout == "" {
+		layout = defaultDateFormat
+	}
+	return expandHome(strings.ReplaceAll(cfg.Obsidian.DailyNote, "{date}", t.Format(layout)))
+}
+
+// appendToDailyNote adds a line about p to today's daily note, creating
+// it if there isn't one yet.
+func appendToDailyNote(p pick) error {
+	path := dailyNotePath(p.Time)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	// Start on a line of its own, if the note doesn't end with one.
+	var sep string
+	if buf, err := os.ReadFile(path); err == nil && len(buf) > 0 && !strings.HasSuffix(string(buf), "\n") {
+		sep = "\n"
+	}
+
+	_, err = fmt.Fprintf(f, "%s- %s Read p. %d of [%s](<%s>)\n", sep, p.Time.Format("15:04"), p.Page, docTitle(p.Doc), docURL(p.Doc))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// The last scan is every document the last run found, before filtering,
+// so --reroll can pick again without walking anything.
+
+type scannedDoc struct {
+	Root string    `json:"root"`
+	Doc  candidate `json:"doc"`
+}
+
+func lastScanPath() string {
+	return filepath.Join(cacheDir(), "last-scan.json")
+}
+
+// saveLastScan keeps docs for the next --reroll.
+func saveLastScan(docs []candidate) error {
+	scanned := make([]scannedDoc, len(docs))
+	for i, doc := range docs {
+		scanned[i] = scannedDoc{Root: doc.Root, Doc: doc}
+	}
+
+	buf, err := json.Marshal(scanned)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir(), 0o755); err != nil {
+		return err
+	}
+	return writeFileAtomic(lastScanPath(), bytes.NewReader(buf))
+}
+
+// loadLastScan returns the documents the last scan found.
+func loadLastScan() ([]candidate, error) {
+	buf, err := os.ReadFile(lastScanPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("nothing has been scanned yet")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var scanned []scannedDoc
+	if err := json.Unmarshal(buf, &scanned); err != nil {
+		return nil, fmt.Errorf("%s: %w", lastScanPath(), err)
+	}
+
+	docs := make([]candidate, len(scanned))
+	for i, s := range scanned {
+		docs[i] = s.Doc
+		docs[i].Root = s.Root
+	}
+	return docs, nil
+}
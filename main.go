@@ -1,181 +1,1108 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log/slog"
 	"math/rand"
-	"net"
-	"net/http"
-	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+)
+
+// randpage scans the files and paths passed on its command line for
+// documents (pdf, epub, djvu, and comic archives), selecting a random one
+// and opening it to a random page. It's a nice way to get a little
+// incremental progress toward reading documents that are otherwise unseen.
 
-	"github.com/pdfcpu/pdfcpu/pkg/api"
+var (
+	configPath     = flag.String("config", defaultConfigPath(), "read settings from the config file at `path`")
+	profile        = flag.String("profile", "", "use the settings from the config file's profile called `name`")
+	null           bool
+	followSymlinks = flag.Bool("follow-symlinks", false, "descend into symlinked directories")
+	includeHidden  = flag.Bool("include-hidden", false, "scan hidden files and directories")
+	sniff          = flag.String("sniff", sniffNone, "identify documents by content: `mode` is none, extensionless, or all")
+	file           = flag.String("file", "", "open the document at `path` (or url) to a random page, without looking for others")
+	reroll         = flag.Bool("reroll", false, "pick again from the documents the last run found, without scanning")
+	continueLast   = flag.Bool("continue", false, "reopen the last document picked (with the same --profile) at the page after the one it was opened to, or pick a new one if there isn't a next page")
+	watchRoots     = flag.Bool("watch", false, "keep watching the roots, updating the index as documents change, instead of opening one")
+	rescan         = flag.Bool("rescan", false, "read every directory, rather than trusting the index for unchanged ones")
+	dirTimeout     = flag.Duration("dir-timeout", 30*time.Second, "give up on directories that take longer than `d` to list (0 for no limit)")
+	fileTimeout    = flag.Duration("file-timeout", time.Minute, "give up on files that take longer than `d` to read or count (0 for no limit)")
+	jobs           = flag.Int("jobs", 8, "read up to `n` directories at once")
+	maxDepth       = flag.Int("max-depth", 0, "read at most `n` levels of each path, counting the path itself as 1, so 1 is only the files directly in it (0 for no limit)")
+	excludes       stringList
+	match          stringList
+	noMatch        stringList
+	tags           stringList
+	atPage         pageSpec
+	minSize        byteSize
+	maxSize        byteSize
+	newerThan      age
+	olderThan      age
+	skipRecent     age
+	recentBias     age
+	strategy       = flag.String("strategy", strategyUniform, "how to choose documents: `name` is "+strings.Join(selectorNames(), ", "))
+	balanceMode    = flag.String("balance", balanceNone, "give each root or directory the same chance: `mode` is none, roots, or dirs")
+	preferUnopened = flag.Bool("prefer-unopened", false, "strongly favor documents that have never been picked")
+	includeDone    = flag.Bool("include-done", false, "pick from documents marked done too")
+	anki           = flag.Bool("anki", false, "add an Anki note with the text of each page picked, through AnkiConnect")
+	streakRead     = flag.Bool("streak-read", false, "only count days with a pick confirmed with randpage read toward the reading streak")
+	sectionStarts  = flag.Bool("sections", false, "open documents to the start of a random chapter or section from their outline, where they have one")
+	chapterMode    = flag.Bool("chapters", false, "open a random chapter of pdfs with an outline, extracted into a pdf of its own")
+	skipBlank      = flag.Bool("skip-blank", false, "pick another page when the one picked is blank, as scanned books are full of")
+	skipAnnotated  = flag.Bool("skip-annotated", false, "pick another page when the one picked has highlights, notes, or drawings on it")
+	skipFront      = flag.Int("skip-front", 0, "don't open documents to their first `n` pages, the title pages and contents")
+	skipBack       = flag.Int("skip-back", 0, "don't open documents to their last `n` pages, the index and notes")
+	count          = flag.Int("count", 1, "open `n` documents, each to a random page")
+	dismissWithin  = flag.Duration("dismiss-within", 0, "count documents closed within `duration` of opening as not interesting, making them less likely (needs a viewer that takes a path and waits until it's closed)")
+	spread         = flag.Int("spread", 1, "open `n` pages of pdfs from the one picked on, extracted into a pdf of their own")
+	extractPages   = flag.Bool("extract", false, "open just the pdf pages picked, extracted into a pdf of their own, rather than the whole pdf at the page")
+	asImage        = flag.Bool("as-image", false, "open the pdf or djvu page picked drawn as a png, in the image viewer")
+	printPage      = flag.Bool("text", false, "print the text of the pdf page or epub chapter picked, rather than opening a viewer")
+	speakPage      = flag.Bool("speak", false, "read the text of the pdf page or epub chapter picked aloud, rather than opening a viewer")
+	inTerminal     = flag.Bool("term", false, "draw the pdf or djvu page picked in the terminal, rather than opening a viewer")
+	termGraphics   = flag.String("term-graphics", graphicsAuto, "draw in the terminal with `protocol` auto, kitty, iterm, or sixel")
+	seed           = flag.Int64("seed", 0, "seed the random choices with `n`, to make the same picks again (0 for a new seed every run)")
+	daily          = flag.Bool("daily", false, "make the same picks all day, from the same library on any machine: a page of the day")
+	cryptoRand     = flag.Bool("crypto-rand", false, "draw every random choice from crypto/rand, so runs can't be made again (unless --seed is given)")
+	keepDuplicates = flag.Bool("keep-duplicates", false, "don't collapse identical copies of a document into one candidate")
+	minPages       = flag.Int("min-pages", 0, "skip documents with fewer than `n` pages")
+	maxPages       = flag.Int("max-pages", 0, "skip documents with more than `n` pages (0 for no limit)")
+	viewerTemplate = flag.String("viewer", "", "open documents with the command `template`, where {path}, {page}, and {url} stand for the file, the page, and its url")
 )
 
-// randpage scans the files and paths passed on its command line for .pdf
-// files, selecting a random one and opening it to a random page. It's a
-// nice way to get a little incremental progress toward reading documents
-// that are otherwise unseen.
+func init() {
+	flag.BoolVar(&null, "0", false, "paths read from stdin are separated by NUL, as from find -print0")
+	flag.BoolVar(&null, "null", false, "same as -0")
+	flag.StringVar(strategy, "weight", strategyUniform, "same as --strategy")
+	flag.Var(&minSize, "min-size", "skip documents smaller than `size`, like 100k or 2M")
+	flag.Var(&maxSize, "max-size", "skip documents larger than `size`, like 500M or 1G")
+	flag.Var(&newerThan, "newer-than", "skip documents last modified longer ago than `age`, like 90d or 2w")
+	flag.Var(&olderThan, "older-than", "skip documents modified more recently than `age`, like 1y or 6m")
+	flag.Var(&skipRecent, "skip-recent", "put off documents opened less than `age` ago, like 7d")
+	flag.Var(&recentBias, "recent-bias", "favor documents modified recently, the newest ten times over, halving every `age`, like 30d")
+	flag.Var(&match, "match", "only pick documents whose path matches the regular expression `re` (repeatable)")
+	flag.Var(&noMatch, "no-match", "skip documents whose path matches the regular expression `re` (repeatable)")
+	flag.Var(&atPage, "page", "open documents to page `n`, first, or last instead of a random one")
+	flag.Var(&tags, "tag", "only pick documents tagged `tag`, by their source or with randpage tag (repeatable)")
+	flag.Var(&excludes, "exclude", "skip files and directories matching a glob `pattern`; a trailing / matches only directories (repeatable)")
+}
 
 func main() {
-	var pdfs []string
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: randpage [flags] [path|url...] (- reads them from stdin)\n       randpage revisit [-page] [0-5] | forget [-page] | tag add|rm|ls ... | ban [file] | unban [file] | snooze [file] [age] | dismiss [file] | rate <1-5> [file] | done [file] | undone [file] | read | undo\n       randpage note <text>... | notes [file] | export [-format md|readwise|csv] [-since age|date]\n       randpage pin [-sequential] [file] | unpin | stats [-n count] [-weeks count]\n       randpage history [-json] [-since age|date] [-file text] | prune [-keep age] [-max count]\n       randpage state export|sync | state import [file]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	for _, arg := range os.Args[1:] {
-		if arg == "-" {
-			pdfs = append(pdfs, readLines(os.Stdin)...)
-			continue
+	var err error
+	cfg, err = loadConfig(*configPath, *profile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+		os.Exit(2)
+	}
+	if err := applyConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+		os.Exit(2)
+	}
+
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		if err := cmd(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+			os.Exit(1)
 		}
+		os.Exit(0)
+	}
 
-		pdfs = append(pdfs, walkForPdfs(arg)...)
+	matter, err = newMatterRules(*skipFront, *skipBack, cfg.Matter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+		os.Exit(2)
 	}
 
-	slog.Info("found candidate pdfs", "count", len(pdfs))
+	switch *sniff {
+	case sniffNone, sniffExtensionless, sniffAll:
+	default:
+		fmt.Fprintf(os.Stderr, "randpage: unknown --sniff mode %q\n", *sniff)
+		os.Exit(2)
+	}
 
-	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-	rnd.Shuffle(len(pdfs), func(i, j int) {
-		pdfs[i], pdfs[j] = pdfs[j], pdfs[i]
-	})
+	sel, ok := selectors[*strategy]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "randpage: unknown --strategy %q, not one of %s\n", *strategy, strings.Join(selectorNames(), ", "))
+		os.Exit(2)
+	}
+
+	if *count < 1 {
+		fmt.Fprintf(os.Stderr, "randpage: --count must be at least 1\n")
+		os.Exit(2)
+	}
+	if *daily && *seed != 0 {
+		fmt.Fprintf(os.Stderr, "randpage: --daily picks its own seed, so it can't be given --seed\n")
+		os.Exit(2)
+	}
+	if *spread < 1 {
+		fmt.Fprintf(os.Stderr, "randpage: --spread must be at least 1\n")
+		os.Exit(2)
+	}
 
-	for len(pdfs) > 0 {
-		path := pdfs[0]
-		pdfs = pdfs[1:]
+	switch *balanceMode {
+	case balanceNone, balanceRoots, balanceDirs:
+	default:
+		fmt.Fprintf(os.Stderr, "randpage: unknown --balance mode %q\n", *balanceMode)
+		os.Exit(2)
+	}
 
-		nPages, err := countPages(path)
+	switch *termGraphics {
+	case graphicsAuto, graphicsKitty, graphicsITerm, graphicsSixel:
+	default:
+		fmt.Fprintf(os.Stderr, "randpage: unknown --term-graphics protocol %q\n", *termGraphics)
+		os.Exit(2)
+	}
+
+	if *continueLast {
+		err := continueReading()
+		if err == nil {
+			os.Exit(0)
+		}
+		if !errors.Is(err, errNothingToContinue) {
+			fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+			os.Exit(1)
+		}
+		slog.Info("picking something new", "err", err)
+	}
+
+	if *file != "" {
+		st, err := loadState()
+		if err == nil {
+			err = read(st, candidate{Path: *file}, false, howFile)
+		}
 		if err != nil {
-			slog.Info("counting pages", "path", path, "err", err)
-			continue
+			fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Pages due for revisiting come before anything random, except in
+	// repeatable runs.
+	if !*watchRoots && *seed == 0 && !*daily {
+		n, err := reviewDue()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+			os.Exit(1)
+		}
+		if *count -= n; n > 0 && *count == 0 {
+			os.Exit(0)
 		}
+	}
 
-		// nPages is 0-indexed; the browsers want 1-indexed.
-		page := rnd.Intn(nPages) + 1
+	if !*watchRoots {
+		if err := readPinned(); err != errNotPinned {
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
 
-		slog.Info("opening pdf", "path", path, "page", page)
+	roots := flag.Args()
+	if len(roots) == 0 {
+		roots = cfg.Roots
+	}
+	if len(roots) == 0 && !*reroll {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-		if err := open(path, rnd.Intn(nPages)+1); err != nil {
-			slog.Error("opening pdf", "path", path, "err", err)
+	matchRE, err := compileAll(match)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: --match: %v\n", err)
+		os.Exit(2)
+	}
+	noMatchRE, err := compileAll(noMatch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: --no-match: %v\n", err)
+		os.Exit(2)
+	}
+
+	// Picking from an empty state would save it over the one that
+	// couldn't be read.
+	st, err := loadState()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: loading state: %v\n", err)
+		os.Exit(1)
+	}
+
+	prio, err := newPriorities(cfg.Priority)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: config %v\n", err)
+		os.Exit(2)
+	}
+	quotas, err := newQuotas(cfg.Quota)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randpage: config %v\n", err)
+		os.Exit(2)
+	}
+
+	w := &walker{
+		followSymlinks: *followSymlinks,
+		excludes:       excludes,
+		match:          matchRE,
+		noMatch:        noMatchRE,
+		tags:           tags,
+		st:             st,
+		includeHidden:  *includeHidden,
+		maxDepth:       *maxDepth,
+		jobs:           *jobs,
+		dirTimeout:     *dirTimeout,
+		fileTimeout:    *fileTimeout,
+		rescan:         *rescan,
+		minSize:        int64(minSize),
+		maxSize:        int64(maxSize),
+		newerThan:      newerThan.cutoff(),
+		olderThan:      olderThan.cutoff(),
+		minPages:       *minPages,
+		maxPages:       *maxPages,
+		pages:          loadPageCounts(),
+		priorities:     prio,
+		balance:        *balanceMode,
+		quotas:         quotas,
+		preferUnopened: *preferUnopened,
+		includeDone:    *includeDone,
+		recentBias:     time.Duration(recentBias),
+		sniff:          *sniff,
+	}
+
+	if *watchRoots {
+		if err := watch(w, roots); err != nil {
+			fmt.Fprintf(os.Stderr, "randpage: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	var docs []candidate
+	if *reroll {
+		if docs, err = loadLastScan(); err != nil {
+			fmt.Fprintf(os.Stderr, "randpage: --reroll: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		for _, arg := range roots {
+			if arg == "-" {
+				docs = append(docs, rooted(readLines(os.Stdin, null), arg)...)
+				continue
+			}
+
+			src, err := newSource(arg, w)
+			if err != nil {
+				slog.Error("opening source", "source", arg, "err", err)
+				continue
+			}
+
+			found, err := src.list()
+			if err != nil {
+				slog.Error("listing source", "source", arg, "err", err)
+				continue
+			}
+			docs = append(docs, rooted(found, arg)...)
+		}
+
+		if err := saveLastScan(docs); err != nil {
+			slog.Info("saving the scan", "err", err)
+		}
+	}
+	docs = w.filter(docs)
+
+	if !*keepDuplicates {
+		docs = dedup(docs)
+	}
+
+	// What's been picked before changes what's picked next, except with
+	// --seed: the same seed picks the same pages from the same documents
+	// every time, for anyone.
+	picks := st
+	if *daily {
+		// The same all day, down to how recent the documents are.
+		now := time.Now()
+		*seed = dailySeed(docs, now)
+		w.recentAsOf = midnight(now)
+	}
+	if *seed == 0 {
+		*seed = newSeed()
+	} else {
+		picks = &state{}
+	}
+
+	slog.Info("found candidate documents", "count", len(docs), "seed", *seed)
+
+	rnd := newRand(*seed)
+	docs = picks.schedule(sel.order(docs, w, picks, rnd), time.Now(), skipRecent.cutoff())
+
+	if len(w.timeouts) > 0 {
+		slog.Warn("gave up on unresponsive paths", "count", len(w.timeouts), "paths", strings.Join(w.timeouts, ", "))
+	}
+	if err := w.pages.save(); err != nil {
+		slog.Info("saving page counts", "err", err)
+	}
+
+	how := howRandom
+	if *daily {
+		how = howDaily
+	} else if *reroll {
+		how = howReroll
+	}
+
+	opened := 0
+	for _, doc := range docs {
+		if opened == *count {
+			break
+		}
+
+		page, viewed, ok := openRandomPage(doc, w, picks, rnd)
+		if !ok {
 			continue
 		}
+		opened++
 
-		// Success
-		os.Exit(0)
+		// Closing a document right away says it wasn't interesting. How
+		// long one was open is only known if the viewer waited.
+		if *dismissWithin > 0 && viewed > 0 {
+			if viewed < *dismissWithin {
+				slog.Info("dismissed document", "path", doc, "viewed", viewed)
+				st.dismissed(doc)
+			} else {
+				st.kept(doc)
+			}
+		}
+		st.picked(newPick(doc, page, how, viewed))
+		afterPick(st)
+		if err := st.save(); err != nil {
+			slog.Info("saving state", "err", err)
+		}
 	}
 
-	fmt.Println("Could not find a usable PDF")
-	os.Exit(1)
+	if err := w.pages.save(); err != nil {
+		slog.Info("saving page counts", "err", err)
+	}
+
+	if opened == 0 {
+		fmt.Println("Could not find a usable document")
+		os.Exit(1)
+	}
+	if opened < *count {
+		slog.Warn("ran out of usable documents", "opened", opened, "count", *count)
+	}
 }
 
-func looksLikePdf(s string) bool {
-	return strings.HasSuffix(strings.ToLower(s), ".pdf")
+// rooted sets the Root of docs, found under root.
+func rooted(docs []candidate, root string) []candidate {
+	for i := range docs {
+		docs[i].Root = root
+	}
+	return docs
 }
 
-func walkForPdfs(arg string) []string {
-	var ret []string
+// applyConfig fills in the flags that weren't given on the command line
+// from the config file. Excludes and match patterns from both places
+// apply.
+func applyConfig(c config) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
 
-	filepath.WalkDir(arg, func(path string, d os.DirEntry, err error) error {
+	if !set["follow-symlinks"] {
+		*followSymlinks = c.FollowSymlinks
+	}
+	if !set["include-hidden"] {
+		*includeHidden = c.IncludeHidden
+	}
+	if !set["max-depth"] {
+		*maxDepth = c.MaxDepth
+	}
+	if !set["strategy"] && !set["weight"] {
+		if c.Weight != "" {
+			*strategy = c.Weight
+		}
+		if c.Strategy != "" {
+			*strategy = c.Strategy
+		}
+	}
+	if !set["balance"] && c.Balance != "" {
+		*balanceMode = c.Balance
+	}
+	if !set["prefer-unopened"] {
+		*preferUnopened = c.PreferUnopened
+	}
+	if !set["include-done"] {
+		*includeDone = c.IncludeDone
+	}
+	if !set["anki"] {
+		*anki = c.Anki.Enabled
+	}
+	if !set["streak-read"] {
+		*streakRead = c.StreakRead
+	}
+	if !set["continue"] {
+		*continueLast = c.Continue
+	}
+	if !set["sections"] {
+		*sectionStarts = c.Sections
+	}
+	if !set["chapters"] {
+		*chapterMode = c.Chapters
+	}
+	if !set["skip-blank"] {
+		*skipBlank = c.SkipBlank
+	}
+	if !set["skip-annotated"] {
+		*skipAnnotated = c.SkipAnnotated
+	}
+	if !set["skip-front"] {
+		*skipFront = c.SkipFront
+	}
+	if !set["skip-back"] {
+		*skipBack = c.SkipBack
+	}
+	if !set["keep-duplicates"] {
+		*keepDuplicates = c.KeepDuplicates
+	}
+	if !set["min-pages"] {
+		*minPages = c.MinPages
+	}
+	if !set["max-pages"] {
+		*maxPages = c.MaxPages
+	}
+	if !set["viewer"] {
+		*viewerTemplate = c.Viewer
+	}
+	for _, v := range c.Viewers {
+		if _, err := parseViewer(v); err != nil {
+			return fmt.Errorf("config viewers: %q: %w", v, err)
+		}
+	}
+	viewerPriority = c.Viewers
+	if !set["spread"] && c.Spread > 0 {
+		*spread = c.Spread
+	}
+	if !set["extract"] {
+		*extractPages = c.Extract
+	}
+	if !set["daily"] {
+		*daily = c.Daily
+	}
+	if !set["crypto-rand"] {
+		*cryptoRand = c.CryptoRand
+	}
+	if !set["jobs"] && c.Jobs > 0 {
+		*jobs = c.Jobs
+	}
+	if !set["dismiss-within"] && c.DismissWithin != "" {
+		d, err := time.ParseDuration(c.DismissWithin)
 		if err != nil {
-			return err
+			return fmt.Errorf("config dismiss_within: %w", err)
+		}
+		*dismissWithin = d
+	}
+	if !set["dir-timeout"] && c.DirTimeout != "" {
+		d, err := time.ParseDuration(c.DirTimeout)
+		if err != nil {
+			return fmt.Errorf("config dir_timeout: %w", err)
+		}
+		*dirTimeout = d
+	}
+	if !set["file-timeout"] && c.FileTimeout != "" {
+		d, err := time.ParseDuration(c.FileTimeout)
+		if err != nil {
+			return fmt.Errorf("config file_timeout: %w", err)
+		}
+		*fileTimeout = d
+	}
+	if !set["sniff"] && c.Sniff != "" {
+		*sniff = c.Sniff
+	}
+
+	if !set["min-size"] && c.MinSize != "" {
+		if err := minSize.Set(c.MinSize); err != nil {
+			return fmt.Errorf("config min_size: %w", err)
 		}
+	}
+	if !set["max-size"] && c.MaxSize != "" {
+		if err := maxSize.Set(c.MaxSize); err != nil {
+			return fmt.Errorf("config max_size: %w", err)
+		}
+	}
 
-		if d.Type().IsRegular() && looksLikePdf(d.Name()) {
-			ret = append(ret, path)
+	if !set["newer-than"] && c.NewerThan != "" {
+		if err := newerThan.Set(c.NewerThan); err != nil {
+			return fmt.Errorf("config newer_than: %w", err)
 		}
+	}
+	if !set["older-than"] && c.OlderThan != "" {
+		if err := olderThan.Set(c.OlderThan); err != nil {
+			return fmt.Errorf("config older_than: %w", err)
+		}
+	}
 
-		return nil
+	if !set["skip-recent"] && c.SkipRecent != "" {
+		if err := skipRecent.Set(c.SkipRecent); err != nil {
+			return fmt.Errorf("config skip_recent: %w", err)
+		}
+	}
+	if !set["recent-bias"] && c.RecentBias != "" {
+		if err := recentBias.Set(c.RecentBias); err != nil {
+			return fmt.Errorf("config recent_bias: %w", err)
+		}
+	}
+
+	excludes = append(append(stringList{}, c.Exclude...), excludes...)
+	match = append(append(stringList{}, c.Match...), match...)
+	noMatch = append(append(stringList{}, c.NoMatch...), noMatch...)
+	tags = append(append(stringList{}, c.Tags...), tags...)
+
+	g, err := newGoals(c.Goals)
+	if err != nil {
+		return fmt.Errorf("config goal: %w", err)
+	}
+	goals = g
+
+	r, err := newRetention(c.History.Keep, c.History.MaxEntries)
+	if err != nil {
+		return fmt.Errorf("config history: %w", err)
+	}
+	historyRetention = r
+
+	st, err := newStore(c.State)
+	if err != nil {
+		return fmt.Errorf("config state: %w", err)
+	}
+	stateStore = st
+
+	return nil
+}
+
+// compileAll compiles each of patterns as a regular expression.
+func compileAll(patterns []string) ([]*regexp.Regexp, error) {
+	var ret []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, re)
+	}
+	return ret, nil
+}
+
+// openRandomPage opens doc to a random page it hasn't been opened to
+// before (or the --page given), returning the page, how long the viewer
+// had it open, and whether it worked. Documents outside w's page range
+// are skipped.
+func openRandomPage(doc candidate, w *walker, st *state, rnd *rand.Rand) (int, time.Duration, bool) {
+	page, viewed, err := openPage(doc, func(nPages int, sections []int) (int, error) {
+		if !w.pageRange(nPages) {
+			return 0, fmt.Errorf("outside page range (%d pages)", nPages)
+		}
+		if atPage.set() {
+			return atPage.in(nPages)
+		}
+		return randomPage(st, doc, nPages, sections, rnd), nil
+	})
+	if err != nil {
+		if doc = statLocal(doc); errors.Is(err, errUnreadable) && !doc.remote() {
+			w.pages.fail(doc, err)
+		}
+		slog.Info("skipping document", "path", doc, "err", err)
+		return 0, 0, false
+	}
+	return page, viewed, true
+}
+
+// errNothingToContinue is why there's no document to continue reading,
+// in which case a new one is picked.
+var errNothingToContinue = errors.New("nothing to continue")
+
+// continueReading opens the last document picked, with the same
+// --profile, to the page after the one it was opened to.
+func continueReading() error {
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+	i := len(st.History) - 1
+	for i >= 0 && st.History[i].Profile != *profile {
+		i--
+	}
+	if i < 0 {
+		return fmt.Errorf("%w: nothing has been picked yet", errNothingToContinue)
+	}
+	last := st.History[i]
+
+	page, viewed, err := openPage(last.Doc, func(nPages int, sections []int) (int, error) {
+		if last.Page >= nPages {
+			return 0, fmt.Errorf("%w: %s is already at the last page", errNothingToContinue, last.Doc)
+		}
+		return last.Page + 1, nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return ret
+	st.picked(newPick(last.Doc, page, howContinue, viewed))
+	afterPick(st)
+	return st.save()
 }
 
-func readLines(r io.Reader) []string {
-	var ret []string
+// afterPick does what's done with each pick once it's recorded in st as
+// st.Last.
+func afterPick(st *state) {
+	showStreak(st)
+	showGoals(st)
 
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		if looksLikePdf(scanner.Text()) {
-			ret = append(ret, scanner.Text())
+	if *anki {
+		if err := addAnkiNote(*st.Last); err != nil {
+			slog.Warn("adding Anki note", "path", st.Last.Doc, "page", st.Last.Page, "err", err)
+		} else {
+			slog.Info("added Anki note", "path", st.Last.Doc, "page", st.Last.Page)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		slog.Error("reading lines", slog.Any("err", err))
+	if cfg.Obsidian.DailyNote != "" {
+		if err := appendToDailyNote(*st.Last); err != nil {
+			slog.Warn("adding to daily note", "err", err)
+		}
 	}
+}
 
-	return ret
+// newPick returns the pick of doc at page, now, made how and viewed for
+// as long as the viewer took.
+func newPick(doc candidate, page int, how string, viewed time.Duration) pick {
+	return pick{Doc: doc, Page: page, Time: time.Now(), Profile: *profile, How: how, Viewed: viewed}
 }
 
-func countPages(path string) (int, error) {
-	doc, err := api.ReadContextFile(path)
+// reviewDue opens the pages due for revisiting, up to --count of them,
+// returning how many it opened. They stay due until they're graded with
+// randpage revisit -page.
+func reviewDue() (int, error) {
+	st, err := loadState()
 	if err != nil {
 		return 0, err
 	}
 
-	return doc.XRefTable.PageCount, nil
+	opened := 0
+	for _, c := range st.duePages(time.Now()) {
+		if opened == *count {
+			break
+		}
+
+		page, viewed, err := openPage(c.Doc, func(nPages int, sections []int) (int, error) {
+			if c.Page > nPages {
+				return 0, fmt.Errorf("no page %d (%d pages)", c.Page, nPages)
+			}
+			return c.Page, nil
+		})
+		if err != nil {
+			slog.Info("skipping page due for revisiting", "path", c.Doc, "page", c.Page, "err", err)
+			continue
+		}
+		opened++
+
+		st.picked(newPick(c.Doc, page, howReview, viewed))
+		afterPick(st)
+		if err := st.save(); err != nil {
+			return opened, err
+		}
+	}
+
+	return opened, nil
 }
 
-// open opens a pdf to the requested page. The browsers don't seem to
-// support the `#page=N` argument on file urls, so this spawns a temporary
-// web server to serve the pdf once. This function blocks until that
-// transfer completes.
-func open(path string, page int) error {
-	buf, err := os.ReadFile(path)
+var errNotPinned = errors.New("no document is pinned")
+
+// errUnreadable is the error for documents that can't be opened at all.
+var errUnreadable = errors.New("unreadable")
+
+// readPinned opens the pinned document, if there is one.
+func readPinned() error {
+	st, err := loadState()
 	if err != nil {
 		return err
 	}
+	if st.Pin == nil {
+		return errNotPinned
+	}
+	return read(st, st.Pin.Doc, st.Pin.Sequential, howPin)
+}
+
+// read opens doc --count times, to pages it hasn't been opened to or, if
+// sequential, each to the page after the last. --page overrides both. The
+// picks are recorded as made how.
+func read(st *state, doc candidate, sequential bool, how string) error {
+	rnd := newRand(newSeed())
+	for i := 0; i < *count; i++ {
+		opened, viewed, err := openPage(doc, func(nPages int, sections []int) (int, error) {
+			if atPage.set() {
+				return atPage.in(nPages)
+			}
+			if !sequential {
+				return randomPage(st, doc, nPages, sections, rnd), nil
+			}
+			first, last := matter.bounds(doc, nPages)
+			if st.Last != nil && st.Last.Doc.key() == doc.key() && st.Last.Page >= first && st.Last.Page < last {
+				return st.Last.Page + 1, nil
+			}
+			return first, nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", doc, err)
+		}
+
+		st.picked(newPick(doc, opened, how, viewed))
+		afterPick(st)
+		if err := st.save(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// randomPage returns a page of doc's nPages it hasn't been opened to,
+// outside its front and back matter. If there are sections, it's the
+// start of one.
+func randomPage(st *state, doc candidate, nPages int, sections []int, rnd *rand.Rand) int {
+	first, last := matter.bounds(doc, nPages)
+
+	var starts []int
+	for _, page := range sections {
+		if page >= first && page <= last {
+			starts = append(starts, page)
+		}
+	}
+	if len(starts) == 0 {
+		starts = sections
+	}
+	if len(starts) > 0 {
+		return st.randomOf(doc, starts, rnd)
+	}
+
+	return st.randomPage(doc, first, last, rnd)
+}
 
-	ln, err := net.Listen("tcp", "127.0.0.1:0")
+// openPage opens doc to the page choose returns given its page count and,
+// with --sections, the pages its sections start on. It returns the page
+// and, if it was opened in a viewer that takes paths and waits to be
+// closed, how long it was looked at; otherwise, as with a browser, whose
+// time is only the transfer's, it returns 0.
+func openPage(doc candidate, choose func(nPages int, sections []int) (int, error)) (int, time.Duration, error) {
+	path, cleanup, err := doc.local()
 	if err != nil {
-		slog.Error("listening", "err", err)
-		return err
+		return 0, 0, fmt.Errorf("reading document: %w", err)
+	}
+	defer cleanup()
+
+	format := documentFormat(doc, path)
+	if format == nil {
+		return 0, 0, fmt.Errorf("unrecognized format")
 	}
-	port := ln.Addr().(*net.TCPAddr).Port
 
-	var wg sync.WaitGroup
-	wg.Add(1)
+	nPages, err := format.countPages(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: counting pages: %w", errUnreadable, err)
+	}
 
-	filename := filepath.Base(path)
-	url := fmt.Sprintf("http://127.0.0.1:%d/%s#page=%d", port, url.PathEscape(filename), page)
+	var sections []int
+	if s, ok := format.(sectioner); ok && (*sectionStarts || *chapterMode) {
+		list := s.sections
+		if *chapterMode {
+			list = s.chapters
+		}
+		if sections, err = list(path); err != nil {
+			slog.Info("reading outline", "path", doc, "err", err)
+		}
+	}
 
-	srv := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			slog.Info("http request", "method", r.Method, "path")
+	page, err := choose(nPages, sections)
+	if err != nil {
+		return 0, 0, err
+	}
 
-			if r.URL.Path != "/"+filename {
-				http.NotFound(w, r)
-				return
+	var skips []pageSkip
+	if b, ok := format.(blankChecker); ok && *skipBlank {
+		skips = append(skips, pageSkip{"blank", b.blankPages})
+	}
+	if a, ok := format.(annotator); ok && *skipAnnotated {
+		skips = append(skips, pageSkip{"annotated", a.annotatedPages})
+	}
+	for _, skip := range skips {
+		pages, err := skip.pages(path)
+		if err != nil {
+			slog.Info("looking for "+skip.what+" pages", "path", doc, "err", err)
+			continue
+		}
+		for tries := 0; tries < maxRerolls && pages(page); tries++ {
+			next, err := choose(nPages, sections)
+			if err != nil {
+				return 0, 0, err
 			}
-
-			w.Header().Set("Content-Type", "application/pdf")
-			w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
-
-			buf := buf
-			for len(buf) > 0 {
-				n, err := w.Write(buf)
-				if err != nil {
-					slog.Error("writing response body", "path", path, "err", err)
-					return
-				}
-				buf = buf[n:]
+			if next == page {
+				// Not a random choice, like --page.
+				break
 			}
-			wg.Done()
-		}),
+			slog.Info("skipping "+skip.what+" page", "path", doc, "page", page)
+			page = next
+		}
 	}
 
-	go srv.Serve(ln)
+	// With --chapters, the spread is the rest of the chapter.
+	last := min(nPages, page+*spread-1)
+	if *chapterMode && len(sections) > 0 {
+		last = nPages
+		if i, _ := slices.BinarySearch(sections, page+1); i < len(sections) {
+			last = sections[i] - 1
+		}
+	}
 
-	cmd := exec.Command("open", url)
-	if err := cmd.Run(); err != nil {
-		slog.Error("executing viewer", "url", url, "err", err)
-		return err
+	slog.Info("opening document", append(doc.logAttrs(), "page", page, "through", last)...)
+
+	start := time.Now()
+	if t, ok := format.(texter); ok && *printPage {
+		if err := printText(os.Stdout, t, doc, path, page, last); err != nil {
+			return 0, 0, fmt.Errorf("reading text: %w", err)
+		}
+		if !*speakPage {
+			return page, 0, nil
+		}
+	}
+	if t, ok := format.(texter); ok && *speakPage {
+		if err := speak(t, doc, path, page, last); err != nil {
+			return 0, 0, fmt.Errorf("speaking: %w", err)
+		}
+		return page, 0, nil
+	}
+	if r, ok := format.(renderer); ok && *inTerminal {
+		if err := showInTerminal(r, path, page); err != nil {
+			return 0, 0, fmt.Errorf("drawing page: %w", err)
+		}
+		return page, 0, nil
+	}
+	if r, ok := format.(renderer); ok && *asImage {
+		if err := openImage(r, path, doc.displayName(), page); err != nil {
+			return 0, 0, fmt.Errorf("opening document: %w", err)
+		}
+		return page, 0, nil
+	}
+	if _, ok := format.(extractor); ok && *extractPages {
+		waited, err := openExtract(format, path, doc.displayName(), page, last)
+		if err != nil {
+			return 0, 0, fmt.Errorf("opening document: %w", err)
+		}
+		if !waited {
+			return page, 0, nil
+		}
+		return page, time.Since(start), nil
+	}
+
+	direct, err := openDirect(format, path, page)
+	switch {
+	case err != nil:
+	case direct:
+		if last > page {
+			slog.Info("opened the whole document, since the viewer takes a path", "path", doc)
+		}
+	default:
+		if s, ok := format.(spreader); ok && last > page {
+			err = s.openSpread(path, doc.displayName(), page, last)
+		} else {
+			err = format.open(path, doc.displayName(), page)
+		}
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("opening document: %w", err)
+	}
+
+	if !direct {
+		return page, 0, nil
+	}
+	return page, time.Since(start), nil
+}
+
+// openExtract opens pages first to last of the document at path, in
+// format f, extracted into a pdf of their own, or the document at first
+// if they can't be. It reports whether a viewer that waits to be closed
+// opened them.
+func openExtract(f format, path, name string, first, last int) (bool, error) {
+	dir, err := os.MkdirTemp("", "randpage")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(dir)
+
+	pages := fmt.Sprintf("p%d", first)
+	if last > first {
+		pages += fmt.Sprintf("-%d", last)
+	}
+	out := filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+" "+pages+".pdf")
+	if err := f.(extractor).extract(path, out, first, last); err != nil {
+		slog.Info("extracting pages", "path", path, "err", err)
+		return false, f.open(path, name, first)
+	}
+
+	direct, err := openDirect(pdfFormat{}, out, 1)
+	if err != nil || direct {
+		return direct, err
+	}
+	return false, pdfFormat{}.open(out, filepath.Base(out), 1)
+}
+
+// A pageSkip is a kind of page to pick another in place of.
+type pageSkip struct {
+	what  string
+	pages func(path string) (func(page int) bool, error)
+}
+
+// stringList is a flag.Value collecting every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// byteSize is a flag.Value for a size in bytes, with an optional k, M, or G
+// suffix (powers of 1024).
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	num := strings.TrimSuffix(strings.TrimSuffix(s, "B"), "b")
+	mult := 1.0
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult != 1 {
+			num = num[:n-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*b = byteSize(f * mult)
+	return nil
+}
+
+// age is a flag.Value for a span of time before now: a number with a unit
+// of h (hours), d (days), w (weeks), m (months of 30 days), or y (years of
+// 365 days).
+type age time.Duration
+
+var ageUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+	'm': 30 * 24 * time.Hour,
+	'y': 365 * 24 * time.Hour,
+}
+
+func (a *age) String() string {
+	return time.Duration(*a).String()
+}
+
+func (a *age) Set(s string) error {
+	if s == "" {
+		return fmt.Errorf("invalid age %q", s)
+	}
+
+	unit, ok := ageUnits[s[len(s)-1]]
+	if !ok {
+		return fmt.Errorf("invalid age %q: needs a unit of h, d, w, m, or y", s)
+	}
+
+	f, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("invalid age %q", s)
+	}
+	*a = age(f * float64(unit))
+	return nil
+}
+
+// cutoff returns the time a's span before now, or the zero time if a
+// wasn't set.
+func (a age) cutoff() time.Time {
+	if a == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(a))
+}
+
+// pageSpec is a flag.Value for the page to open documents to: a number,
+// first, or last. The zero value means a random page.
+type pageSpec int
+
+// pageLast is the pageSpec for the last page.
+const pageLast pageSpec = -1
+
+func (p *pageSpec) String() string {
+	switch *p {
+	case 0:
+		return ""
+	case pageLast:
+		return "last"
+	}
+	return strconv.Itoa(int(*p))
+}
+
+func (p *pageSpec) Set(s string) error {
+	switch s {
+	case "first":
+		*p = 1
+		return nil
+	case "last":
+		*p = pageLast
+		return nil
 	}
 
-	wg.Wait()
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid page %q: want a number, first, or last", s)
+	}
+	*p = pageSpec(n)
 	return nil
 }
+
+func (p pageSpec) set() bool {
+	return p != 0
+}
+
+// in returns the page in a document of n pages.
+func (p pageSpec) in(n int) (int, error) {
+	if p == pageLast {
+		return n, nil
+	}
+	if int(p) > n {
+		return 0, fmt.Errorf("no page %d in %d pages", p, n)
+	}
+	return int(p), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A matterRule gives the number of pages of front matter (title pages,
+// copyright notices, contents) and back matter (indexes) in the documents
+// matching it.
+type matterRule struct {
+	re          *regexp.Regexp
+	dirOnly     bool
+	front, back int
+}
+
+// matterRules say how many pages at the start and end of documents aren't
+// worth opening to: the most specific rule matching a document, as for
+// priorities, or the defaults.
+type matterRules struct {
+	front, back int
+	rules       []matterRule
+}
+
+// matter are the rules from --skip-front, --skip-back, and the config's
+// [matter] tables.
+var matter = &matterRules{}
+
+// newMatterRules returns the rules in table, patterns as for priorities,
+// with front and back as the defaults.
+func newMatterRules(front, back int, table map[string]matterConfig) (*matterRules, error) {
+	if front < 0 || back < 0 {
+		return nil, fmt.Errorf("negative page count")
+	}
+
+	m := &matterRules{front: front, back: back}
+	for _, pattern := range sortedPatterns(table) {
+		c := table[pattern]
+		if c.Front < 0 || c.Back < 0 {
+			return nil, fmt.Errorf("matter %q: negative page count", pattern)
+		}
+
+		re, dirOnly, err := compilePattern(globalPattern(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("matter %q: %w", pattern, err)
+		}
+		m.rules = append(m.rules, matterRule{re: re, dirOnly: dirOnly, front: c.Front, back: c.Back})
+	}
+
+	return m, nil
+}
+
+// bounds returns the first and last pages of doc's n worth opening to.
+// Documents too short to skip anything are opened anywhere.
+func (m *matterRules) bounds(doc candidate, n int) (int, int) {
+	front, back := m.front, m.back
+	eachTarget(doc, func(target string, isDir bool) bool {
+		rel := strings.TrimPrefix(target, "/")
+		for i := len(m.rules) - 1; i >= 0; i-- {
+			rule := m.rules[i]
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				front, back = rule.front, rule.back
+				return true
+			}
+		}
+		return false
+	})
+
+	if front+back >= n {
+		return 1, n
+	}
+	return front + 1, n - back
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pageCounts caches the page counts of local documents, so page range
+// filters don't have to open every document on every run, and the reason
+// for the ones that couldn't be counted, so they aren't tried again. An
+// entry is good as long as the document's size and modification time
+// match. The reasons are kept with the state, in its database if it has
+// one.
+type pageCounts struct {
+	path    string
+	entries map[string]pageCount
+	dirty   bool
+	db      *sqliteStore
+}
+
+type pageCount struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Pages   int       `json:"pages"`
+	Err     string    `json:"err,omitempty"`
+}
+
+func loadPageCounts() *pageCounts {
+	pc := &pageCounts{
+		path:    filepath.Join(cacheDir(), "pages.json"),
+		entries: make(map[string]pageCount),
+	}
+
+	buf, err := os.ReadFile(pc.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(buf, &pc.entries); err != nil {
+			slog.Info("reading page counts", "path", pc.path, "err", err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		slog.Info("reading page counts", "path", pc.path, "err", err)
+	}
+	pc.loadFailures()
+
+	return pc
+}
+
+// loadFailures reads the failures from the state's database, if it has
+// one, in place of any left in the cache by older randpages.
+func (pc *pageCounts) loadFailures() {
+	db, ok := stateDatabase()
+	if !ok || !db.exists() {
+		return
+	}
+	failures, err := db.loadFailures()
+	if err != nil {
+		slog.Info("reading failed documents", "path", db.path, "err", err)
+		return
+	}
+	pc.db = &db
+
+	for key, e := range pc.entries {
+		if e.Err != "" {
+			if _, ok := failures[key]; !ok {
+				failures[key] = e
+			}
+			delete(pc.entries, key)
+			pc.dirty = true
+		}
+	}
+	for key, e := range failures {
+		pc.entries[key] = e
+	}
+}
+
+// count returns the number of pages in doc, counting them if the cache
+// doesn't know and giving up after timeout. doc's Size and ModTime must be
+// filled in.
+func (pc *pageCounts) count(doc candidate, timeout time.Duration) (int, error) {
+	if reason, ok := pc.failed(doc); ok {
+		return 0, fmt.Errorf("failed before: %s", reason)
+	}
+	key := doc.String()
+	if e, ok := pc.entries[key]; ok && e.Size == doc.Size && e.ModTime.Equal(doc.ModTime) {
+		return e.Pages, nil
+	}
+
+	n, err := withTimeout(timeout, func() (int, error) { return countPages(doc) })
+	if err != nil {
+		// A slow mount might do better next time.
+		if !errors.Is(err, errTimeout) {
+			pc.fail(doc, err)
+		}
+		return 0, err
+	}
+
+	pc.entries[key] = pageCount{Size: doc.Size, ModTime: doc.ModTime, Pages: n}
+	pc.dirty = true
+
+	return n, nil
+}
+
+// known reports whether the cache has doc's page count, so counting it
+// won't open it.
+func (pc *pageCounts) known(doc candidate) bool {
+	e, ok := pc.entries[doc.String()]
+	return ok && e.Err == "" && e.Size == doc.Size && e.ModTime.Equal(doc.ModTime)
+}
+
+// failed returns why doc couldn't be counted, if it couldn't be as it is
+// now.
+func (pc *pageCounts) failed(doc candidate) (string, bool) {
+	e, ok := pc.entries[doc.String()]
+	if !ok || e.Err == "" || e.Size != doc.Size || !e.ModTime.Equal(doc.ModTime) {
+		return "", false
+	}
+	return e.Err, true
+}
+
+// fail records that doc couldn't be counted, until it changes. A tool
+// that couldn't be run, like djvused not being installed, isn't the
+// document's fault, so it's tried again next time; one that ran and
+// failed on the document gives the reason on its stderr.
+func (pc *pageCounts) fail(doc candidate, err error) {
+	if toolFailed(err) {
+		return
+	}
+	reason := err.Error()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if stderr := strings.TrimSpace(string(exitErr.Stderr)); stderr != "" {
+			reason += ": " + stderr
+		}
+	}
+	pc.entries[doc.String()] = pageCount{Size: doc.Size, ModTime: doc.ModTime, Err: reason}
+	pc.dirty = true
+}
+
+// save writes the cache back if anything was counted.
+func (pc *pageCounts) save() error {
+	if !pc.dirty {
+		return nil
+	}
+
+	counts := pc.entries
+	if pc.db != nil {
+		if err := pc.db.saveFailures(pc.entries); err != nil {
+			return err
+		}
+		counts = make(map[string]pageCount)
+		for key, e := range pc.entries {
+			if e.Err == "" {
+				counts[key] = e
+			}
+		}
+	}
+
+	buf, err := json.Marshal(counts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(pc.path), 0o755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(pc.path, bytes.NewReader(buf))
+}
+
+// toolFailed reports whether err came from an external tool that
+// couldn't be run at all, rather than from reading the document.
+func toolFailed(err error) bool {
+	var execErr *exec.Error
+	return errors.As(err, &execErr)
+}
+
+// countPages opens doc to count its pages.
+func countPages(doc candidate) (int, error) {
+	path, cleanup, err := doc.local()
+	if err != nil {
+		return 0, err
+	}
+	defer cleanup()
+
+	format := documentFormat(doc, path)
+	if format == nil {
+		return 0, fmt.Errorf("%s: unrecognized format", doc)
+	}
+
+	return format.countPages(path)
+}
+
+// documentFormat returns the format of doc, whose contents are at path.
+func documentFormat(doc candidate, path string) format {
+	if format := doc.format(); format != nil {
+		return format
+	}
+
+	// Remote documents don't always have a telling name.
+	return formats[sniffFormat(path)]
+}
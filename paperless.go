@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paperlessSource lists the documents in a Paperless-ngx instance through
+// its REST API. paperless: alone lists everything; paperless:<tag> only
+// the documents with that tag. The server's url and an API token come
+// from RANDPAGE_PAPERLESS_URL and RANDPAGE_PAPERLESS_TOKEN, or the config
+// file.
+//
+// Candidates are named paperless:<document id>/<file name>, like Drive's.
+type paperlessSource struct {
+	tag string
+	w   *walker
+}
+
+func newPaperlessSource(arg string, w *walker) (source, error) {
+	return paperlessSource{tag: strings.TrimPrefix(arg, "paperless:"), w: w}, nil
+}
+
+type paperlessPage struct {
+	Next    string `json:"next"`
+	Results []struct {
+		ID               int       `json:"id"`
+		Title            string    `json:"title"`
+		OriginalFileName string    `json:"original_file_name"`
+		ArchivedFileName string    `json:"archived_file_name"`
+		Modified         time.Time `json:"modified"`
+		Tags             []int     `json:"tags"`
+	} `json:"results"`
+}
+
+func (s paperlessSource) list() ([]candidate, error) {
+	tags, err := paperlessTags()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"page_size": {"100"}}
+	if s.tag != "" {
+		query.Set("tags__name__iexact", s.tag)
+	}
+	next, err := paperlessURL("/api/documents/?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []candidate
+	for next != "" {
+		var page paperlessPage
+		if err := paperlessGet(next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, doc := range page.Results {
+			// The download is the archived pdf if Paperless made one.
+			name := doc.ArchivedFileName
+			if name == "" {
+				name = doc.OriginalFileName
+			}
+			name = strings.ReplaceAll(name, "/", "_")
+			if !s.w.keepRemote("", "/"+name) {
+				continue
+			}
+
+			c := candidate{
+				Path:    "paperless:" + strconv.Itoa(doc.ID) + "/" + name,
+				Title:   doc.Title,
+				ModTime: doc.Modified,
+			}
+			for _, id := range doc.Tags {
+				c.Tags = append(c.Tags, tags[id])
+			}
+			ret = append(ret, c)
+		}
+
+		next = page.Next
+	}
+
+	return ret, nil
+}
+
+func (s paperlessSource) fetch(c candidate, w io.Writer) error {
+	id, _, _ := strings.Cut(strings.TrimPrefix(c.Path, "paperless:"), "/")
+
+	u, err := paperlessURL("/api/documents/" + url.PathEscape(id) + "/download/")
+	if err != nil {
+		return err
+	}
+	resp, err := paperlessRequest(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// paperlessTags returns the names of the instance's tags by id.
+func paperlessTags() (map[int]string, error) {
+	type tagPage struct {
+		Next    string `json:"next"`
+		Results []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"results"`
+	}
+
+	next, err := paperlessURL("/api/tags/?page_size=1000")
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[int]string)
+	for next != "" {
+		var page tagPage
+		if err := paperlessGet(next, &page); err != nil {
+			return nil, err
+		}
+		for _, tag := range page.Results {
+			ret[tag.ID] = tag.Name
+		}
+		next = page.Next
+	}
+
+	return ret, nil
+}
+
+// paperlessURL returns the url of an API path on the configured server.
+func paperlessURL(path string) (string, error) {
+	base := envOr("RANDPAGE_PAPERLESS_URL", cfg.Paperless.URL)
+	if base == "" {
+		return "", errors.New("paperless: set RANDPAGE_PAPERLESS_URL or [paperless] url in the config file")
+	}
+	return strings.TrimSuffix(base, "/") + path, nil
+}
+
+func paperlessGet(u string, v any) error {
+	resp, err := paperlessRequest(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func paperlessRequest(u string) (*http.Response, error) {
+	token := envOr("RANDPAGE_PAPERLESS_TOKEN", cfg.Paperless.Token)
+	if token == "" {
+		return nil, errors.New("paperless: set RANDPAGE_PAPERLESS_TOKEN or [paperless] token in the config file")
+	}
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Token "+token)
+
+	return doHTTP(req)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+type pdfFormat struct{}
+
+func (pdfFormat) countPages(path string) (int, error) {
+	doc, err := api.ReadContextFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return doc.XRefTable.PageCount, nil
+}
+
+// open opens a pdf to the requested page. The browsers don't seem to
+// support the `#page=N` argument on file urls, so this serves the pdf once
+// from a temporary web server.
+func (pdfFormat) open(path, name string, page int) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return serveBytes(name, "application/pdf", buf, "page="+strconv.Itoa(page))
+}
+
+// openSpread opens pages first to last of a pdf, extracted into a pdf of
+// their own, or just the first if they can't be extracted.
+func (f pdfFormat) openSpread(path, name string, first, last int) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var buf bytes.Buffer
+	if err := api.Trim(in, &buf, []string{fmt.Sprintf("%d-%d", first, last)}, nil); err != nil {
+		slog.Info("extracting pages", "path", path, "err", err)
+		return f.open(path, name, first)
+	}
+
+	return serveBytes(name, "application/pdf", buf.Bytes(), "page=1")
+}
+
+// extract writes pages first to last of the pdf at path to a pdf of
+// their own at out.
+func (pdfFormat) extract(path, out string, first, last int) error {
+	return api.TrimFile(path, out, []string{fmt.Sprintf("%d-%d", first, last)}, nil)
+}
+
+// sections returns the pages the pdf's outline entries start on.
+func (pdfFormat) sections(path string) ([]int, error) {
+	bookmarks, err := outline(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []int
+	var add func(bms []pdfcpu.Bookmark)
+	add = func(bms []pdfcpu.Bookmark) {
+		for _, bm := range bms {
+			if bm.PageFrom > 0 {
+				ret = append(ret, bm.PageFrom)
+			}
+			add(bm.Kids)
+		}
+	}
+	add(bookmarks)
+
+	slices.Sort(ret)
+	return slices.Compact(ret), nil
+}
+
+// chapters returns the pages the pdf's top-level outline entries start
+// on. An outline with one entry at the top, like the title of the book,
+// has its chapters one level down.
+func (pdfFormat) chapters(path string) ([]int, error) {
+	bookmarks, err := outline(path)
+	if err != nil {
+		return nil, err
+	}
+	for len(bookmarks) == 1 {
+		bookmarks = bookmarks[0].Kids
+	}
+
+	var ret []int
+	for _, bm := range bookmarks {
+		if bm.PageFrom > 0 {
+			ret = append(ret, bm.PageFrom)
+		}
+	}
+
+	slices.Sort(ret)
+	return slices.Compact(ret), nil
+}
+
+func outline(path string) ([]pdfcpu.Bookmark, error) {
+	doc, err := api.ReadContextFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return pdfcpu.Bookmarks(doc)
+}
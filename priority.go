@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// priorityFile is the name of the sidecar file giving priorities to the
+// documents in and below its directory.
+const priorityFile = ".randpagepriority"
+
+type priorityRule struct {
+	re      *regexp.Regexp
+	dirOnly bool
+	weight  float64
+}
+
+// A priorityList is a set of rules giving weights to paths, relative to
+// some directory. Later rules take precedence over earlier ones.
+type priorityList []priorityRule
+
+// lookup returns the weight the last rule matching rel gives it.
+func (l priorityList) lookup(rel string, isDir bool) (float64, bool) {
+	for i := len(l) - 1; i >= 0; i-- {
+		rule := l[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			return rule.weight, true
+		}
+	}
+	return 0, false
+}
+
+// priorities multiply the chance of picking documents, by the rules in the
+// config file and in the priority files next to them.
+type priorities struct {
+	// global are the rules from the config file, relative to /.
+	global priorityList
+
+	// dirs are the rules from the priority file in each directory looked
+	// at so far, nil if there isn't one.
+	dirs map[string]priorityList
+}
+
+// newPriorities returns priorities with the rules in table, which maps
+// gitignore-style patterns to weights. Patterns match at any depth
+// unless they start with / or ~/. Longer patterns take precedence.
+func newPriorities(table map[string]float64) (*priorities, error) {
+	p := &priorities{dirs: make(map[string]priorityList)}
+	for _, pattern := range sortedPatterns(table) {
+		rule, err := priorityRuleFor(globalPattern(pattern), table[pattern])
+		if err != nil {
+			return nil, fmt.Errorf("priority %q: %w", pattern, err)
+		}
+		p.global = append(p.global, rule)
+	}
+
+	return p, nil
+}
+
+func priorityRuleFor(pattern string, weight float64) (priorityRule, error) {
+	if weight < 0 {
+		return priorityRule{}, fmt.Errorf("negative weight %v", weight)
+	}
+
+	re, dirOnly, err := compilePattern(pattern)
+	if err != nil {
+		return priorityRule{}, err
+	}
+	return priorityRule{re: re, dirOnly: dirOnly, weight: weight}, nil
+}
+
+// parsePriority parses a priority file: lines of "pattern = weight", with
+// patterns as in an ignore file.
+func parsePriority(r io.Reader) (priorityList, error) {
+	var ret priorityList
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%q: expected pattern = weight", line)
+		}
+		pattern := strings.TrimSpace(line[:i])
+		weight, err := strconv.ParseFloat(strings.TrimSpace(line[i+1:]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", line, err)
+		}
+
+		rule, err := priorityRuleFor(pattern, weight)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", line, err)
+		}
+		ret = append(ret, rule)
+	}
+
+	return ret, scanner.Err()
+}
+
+// dir returns the rules in dir's priority file.
+func (p *priorities) dir(dir string) priorityList {
+	if l, ok := p.dirs[dir]; ok {
+		return l
+	}
+
+	var l priorityList
+	path := filepath.Join(dir, priorityFile)
+	f, err := os.Open(path)
+	if err == nil {
+		l, err = parsePriority(f)
+		f.Close()
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Info("reading priority file", "path", path, "err", err)
+	}
+
+	p.dirs[dir] = l
+	return l
+}
+
+// weight returns the priority of doc: the weight of the most specific
+// rule that matches it, a rule for the document itself beating ones for
+// the directories it's in. Documents no rule matches have a weight of 1.
+func (p *priorities) weight(doc candidate) float64 {
+	// Priority files only apply to local documents, from the directory
+	// it's in up.
+	var sidecars []string
+	if !doc.remote() {
+		for dir := filepath.Dir(doc.abs().Path); ; dir = filepath.Dir(dir) {
+			sidecars = append(sidecars, dir)
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+
+	weight := 1.0
+	eachTarget(doc, func(target string, isDir bool) bool {
+		for _, dir := range sidecars {
+			rel := relSlash(dir, target)
+			if rel == ".." || strings.HasPrefix(rel, "../") {
+				continue
+			}
+			if w, ok := p.dir(dir).lookup(rel, isDir); ok {
+				weight = w
+				return true
+			}
+		}
+
+		w, ok := p.global.lookup(strings.TrimPrefix(target, "/"), isDir)
+		if ok {
+			weight = w
+		}
+		return ok
+	})
+	return weight
+}
+
+// eachTarget calls f with the slash-separated path of doc and then of
+// each directory it's in, from the nearest up, until f returns true.
+func eachTarget(doc candidate, f func(target string, isDir bool) bool) {
+	target := filepath.ToSlash(doc.abs().Path)
+	if doc.Member != "" {
+		target += "/" + doc.Member
+	}
+
+	for isDir := false; !f(target, isDir); isDir = true {
+		i := strings.LastIndexByte(strings.TrimSuffix(target, "/"), '/')
+		if i <= 0 {
+			return
+		}
+		target = target[:i]
+	}
+}
+
+// globalPattern returns the pattern to match a config file pattern
+// against whole paths: at any depth, unless it starts with / or ~/.
+func globalPattern(pattern string) string {
+	glob := filepath.ToSlash(expandHome(pattern))
+	if !strings.HasPrefix(glob, "/") {
+		glob = "**/" + glob
+	}
+	return glob
+}
+
+// sortedPatterns returns the patterns in table shortest first, so the
+// longer ones, which tend to be more specific, take precedence.
+func sortedPatterns[T any](table map[string]T) []string {
+	patterns := make([]string, 0, len(table))
+	for pattern := range table {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) < len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	return patterns
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// quotaWindow is how many of the latest picks quotas are measured over.
+const quotaWindow = 100
+
+// A category is a share of the picks to give the documents with one of
+// its tags or under one of its paths.
+type category struct {
+	name  string
+	share float64
+	tags  []string
+	paths ignoreList
+}
+
+// quotas divide picks among categories of documents in proportion to
+// their shares. Documents in no category share what's left over.
+type quotas struct {
+	categories []category
+	rest       float64
+}
+
+// newQuotas returns the quotas in table, by category name. A category
+// with no tags or paths takes in every document the others don't.
+func newQuotas(table map[string]quotaConfig) (*quotas, error) {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	q := &quotas{rest: 1}
+	var catchAll *category
+	for _, name := range names {
+		c := table[name]
+		if c.Share < 0 {
+			return nil, fmt.Errorf("quota %q: negative share %v", name, c.Share)
+		}
+
+		cat := category{name: name, share: c.Share, tags: c.Tags}
+		for _, pattern := range c.Paths {
+			re, dirOnly, err := compilePattern(globalPattern(pattern))
+			if err != nil {
+				return nil, fmt.Errorf("quota %q: %w", name, err)
+			}
+			cat.paths = append(cat.paths, ignoreRule{re: re, dirOnly: dirOnly})
+		}
+		q.rest -= c.Share
+
+		if len(cat.tags) == 0 && len(cat.paths) == 0 {
+			if catchAll != nil {
+				return nil, fmt.Errorf("quota %q: only one category can have no tags or paths, and %q has none", name, catchAll.name)
+			}
+			catchAll = &cat
+			continue
+		}
+		q.categories = append(q.categories, cat)
+	}
+
+	if catchAll != nil {
+		q.categories = append(q.categories, *catchAll)
+		q.rest = 0
+	}
+	q.rest = max(0, q.rest)
+
+	return q, nil
+}
+
+// category returns the index of the first category doc is in, or -1 for
+// the rest.
+func (q *quotas) category(doc candidate, st *state) int {
+	for i, cat := range q.categories {
+		if len(cat.tags) == 0 && len(cat.paths) == 0 {
+			return i
+		}
+		if len(cat.tags) > 0 && st.tagged(doc, cat.tags) {
+			return i
+		}
+
+		// The paths match like an ignore file's, so "ignored" means in
+		// the category.
+		in := false
+		eachTarget(doc, func(target string, isDir bool) bool {
+			in = cat.paths.ignored(strings.TrimPrefix(target, "/"), isDir)
+			return in
+		})
+		if in {
+			return i
+		}
+	}
+	return -1
+}
+
+// scale scales weights so the chance of the next pick coming from each
+// category closes the gap between its share and its part of the last
+// quotaWindow picks in st's history. Categories with no documents in docs
+// give their shares to the others.
+func (q *quotas) scale(docs []candidate, weights []float64, st *state) {
+	if len(q.categories) == 0 {
+		return
+	}
+
+	// Index 0 is the rest; categories are shifted up by one.
+	cats := make([]int, len(docs))
+	totals := make([]float64, len(q.categories)+1)
+	for i, doc := range docs {
+		cats[i] = q.category(doc, st) + 1
+		totals[cats[i]] += weights[i]
+	}
+
+	shares := []float64{q.rest}
+	for _, cat := range q.categories {
+		shares = append(shares, cat.share)
+	}
+	var sum float64
+	for c := range shares {
+		if totals[c] == 0 {
+			shares[c] = 0
+		}
+		sum += shares[c]
+	}
+	if sum == 0 {
+		return
+	}
+
+	history := st.History[max(0, len(st.History)-quotaWindow):]
+	picked := make([]float64, len(shares))
+	for _, p := range history {
+		picked[q.category(p.Doc, st)+1]++
+	}
+
+	// Each category's chance is how far behind its share it would be
+	// after one more pick, if that pick went to something else.
+	n := float64(len(history) + 1)
+	chances := make([]float64, len(shares))
+	for c := range shares {
+		chances[c] = max(0, shares[c]/sum*n-picked[c])
+	}
+
+	for i := range docs {
+		if total := totals[cats[i]]; total > 0 {
+			weights[i] *= chances[cats[i]] / total
+		}
+	}
+}
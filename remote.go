@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// urlName returns the file name at the end of a url's path.
+func urlName(rawURL string) string {
+	name := path.Base(rawURL)
+	if u, err := url.Parse(rawURL); err == nil && u.Opaque == "" {
+		// Opaque urls, like gdrive:<id>/<name>, are paths already.
+		name = path.Base(u.Path)
+	}
+
+	if name == "/" || name == "." {
+		return "document"
+	}
+	return name
+}
+
+// httpSource is a single document at an http or https url, or a directory
+// listing to crawl if the url ends in a slash.
+type httpSource struct {
+	u *url.URL
+	w *walker
+}
+
+func newHTTPSource(arg string, w *walker) (source, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	return httpSource{u: u, w: w}, nil
+}
+
+func (s httpSource) list() ([]candidate, error) {
+	if strings.HasSuffix(s.u.Path, "/") {
+		return s.w.crawl(s.u.String()), nil
+	}
+	return []candidate{{Path: s.u.String()}}, nil
+}
+
+func (s httpSource) fetch(c candidate, w io.Writer) error {
+	req, err := http.NewRequest("GET", c.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := doHTTP(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// httpVersion returns what tells versions of the document at u apart:
+// the ETag, Last-Modified, and Content-Length a HEAD request gets.
+func httpVersion(u string) (string, error) {
+	req, err := http.NewRequest("HEAD", u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doHTTP(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	h := resp.Header
+	return strings.Join([]string{h.Get("ETag"), h.Get("Last-Modified"), h.Get("Content-Length")}, "\n"), nil
+}
+
+// doHTTP sends req, treating any status but 2xx as an error.
+func doHTTP(req *http.Request) (*http.Response, error) {
+	req.Header.Set("User-Agent", "randpage")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		// Leave out the query, which may hold an API key.
+		where := url.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: req.URL.Path}
+		return nil, fmt.Errorf("%s %s: %s", req.Method, where.String(), resp.Status)
+	}
+
+	return resp, nil
+}
+
+// hrefPattern finds links in a directory listing. Autoindex pages are
+// simple enough that this doesn't need a real HTML parser.
+var hrefPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"'#?]+)["']`)
+
+// crawl finds documents in an Apache- or nginx-style directory listing at
+// root, following links to subdirectories below it. Nothing is downloaded
+// but the listings themselves.
+func (w *walker) crawl(root string) []candidate {
+	base, err := url.Parse(root)
+	if err != nil {
+		slog.Info("crawling listing", "url", root, "err", err)
+		return nil
+	}
+
+	var ret []candidate
+	visited := make(map[string]bool)
+
+	var visit func(page *url.URL, depth int)
+	visit = func(page *url.URL, depth int) {
+		if visited[page.String()] {
+			return
+		}
+		visited[page.String()] = true
+
+		links, err := listingLinks(page)
+		if err != nil {
+			slog.Info("crawling listing", "url", page, "err", err)
+			return
+		}
+
+		for _, link := range links {
+			// Stay below root; this also skips the parent directory link.
+			if link.Host != base.Host || !strings.HasPrefix(link.Path, base.Path) || link.Path == page.Path {
+				continue
+			}
+
+			isDir := strings.HasSuffix(link.Path, "/")
+			if w.skipRemote(base.Path, link.Path, isDir) {
+				continue
+			}
+
+			if isDir {
+				if w.maxDepth == 0 || depth+1 < w.maxDepth {
+					visit(link, depth+1)
+				}
+			} else if looksLikeDocument(path.Base(link.Path)) {
+				ret = append(ret, candidate{Path: link.String()})
+			}
+		}
+	}
+	visit(base, 0)
+
+	return ret
+}
+
+// listingLinks fetches a listing page and returns the absolute urls it
+// links to.
+func listingLinks(page *url.URL) ([]*url.URL, error) {
+	req, err := http.NewRequest("GET", page.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doHTTP(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*url.URL
+	for _, m := range hrefPattern.FindAllSubmatch(body, -1) {
+		ref, err := url.Parse(html.UnescapeString(string(m[1])))
+		if err != nil {
+			continue
+		}
+		ret = append(ret, page.ResolveReference(ref))
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// newRand returns the source of every random choice: a repeatable one for
+// a seed, or with a seed of 0 one that draws from crypto/rand.
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		return rand.New(cryptoSource{})
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// newSeed returns the seed for a run without --seed: a random one, or 0
+// with --crypto-rand.
+func newSeed() int64 {
+	if *cryptoRand {
+		return 0
+	}
+	return randomSeed()
+}
+
+// randomSeed returns a seed from crypto/rand, so runs started close
+// together don't pick alike the way seeds from the clock can. It's never
+// 0.
+func randomSeed() int64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		return time.Now().UnixNano() | 1
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:])>>1) | 1
+}
+
+// dailySeed returns the seed for --daily, from the date of day and the
+// names of docs relative to their roots, so it's the same all day for the
+// same library wherever it's kept.
+func dailySeed(docs []candidate, day time.Time) int64 {
+	h := sha256.New()
+	io.WriteString(h, day.Format(time.DateOnly))
+	for _, doc := range docs {
+		name := doc.String()
+		if !doc.remote() {
+			name = relSlash(doc.Root, name)
+		}
+		io.WriteString(h, "\n"+name)
+	}
+	return int64(binary.LittleEndian.Uint64(h.Sum(nil))>>1) | 1
+}
+
+// cryptoSource is a rand.Source reading from crypto/rand, which can't be
+// seeded.
+type cryptoSource struct{}
+
+func (cryptoSource) Int63() int64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]) >> 1)
+}
+
+func (cryptoSource) Seed(int64) {}
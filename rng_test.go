@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDailySeed(t *testing.T) {
+	day := time.Date(2024, 3, 1, 9, 0, 0, 0, time.Local)
+	library := func(root string) []candidate {
+		return []candidate{
+			{Path: root + "/a.pdf", Root: root},
+			{Path: root + "/books/b.epub", Root: root},
+		}
+	}
+
+	seed := dailySeed(library("/home/me/papers"), day)
+	if got := dailySeed(library("/home/me/papers"), day.Add(12*time.Hour)); got != seed {
+		t.Errorf("later the same day: seed = %d, want %d", got, seed)
+	}
+	if got := dailySeed(library("/mnt/backup/papers"), day); got != seed {
+		t.Errorf("library kept elsewhere: seed = %d, want %d", got, seed)
+	}
+	if got := dailySeed(library("/home/me/papers"), day.AddDate(0, 0, 1)); got == seed {
+		t.Errorf("the next day: seed = %d, want another", got)
+	}
+	if got := dailySeed(library("/home/me/papers")[:1], day); got == seed {
+		t.Errorf("another library: seed = %d, want another", got)
+	}
+}
+
+func TestNewRandSeeded(t *testing.T) {
+	a, b := newRand(42), newRand(42)
+	for i := 0; i < 10; i++ {
+		if x, y := a.Int63(), b.Int63(); x != y {
+			t.Fatalf("draw %d: %d and %d from the same seed", i, x, y)
+		}
+	}
+}
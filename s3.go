@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Source lists documents under an s3://bucket/prefix url. Credentials and
+// region come from the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, and AWS_REGION variables. AWS_ENDPOINT_URL points it
+// at an S3-compatible service instead.
+type s3Source struct {
+	bucket string
+	prefix string
+	w      *walker
+}
+
+func newS3Source(arg string, w *walker) (source, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s: missing bucket", u)
+	}
+	return s3Source{bucket: u.Host, prefix: strings.TrimPrefix(u.Path, "/"), w: w}, nil
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s s3Source) list() ([]candidate, error) {
+	var ret []candidate
+
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		resp, err := s.request("GET", "", query)
+		if err != nil {
+			return nil, err
+		}
+
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			if s.w.keepRemote("/"+strings.TrimSuffix(s.prefix, "/"), "/"+obj.Key) {
+				ret = append(ret, candidate{Path: "s3://" + s.bucket + "/" + obj.Key, Size: obj.Size, ModTime: obj.LastModified})
+			}
+		}
+
+		if !result.IsTruncated {
+			return ret, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s s3Source) fetch(c candidate, w io.Writer) error {
+	key, ok := strings.CutPrefix(c.Path, "s3://"+s.bucket+"/")
+	if !ok {
+		return fmt.Errorf("%s: not in bucket %s", c, s.bucket)
+	}
+
+	resp, err := s.request("GET", key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// request sends a signed request for key (or the bucket itself, if key is
+// empty).
+func (s s3Source) request(method, key string, query url.Values) (*http.Response, error) {
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	// Virtual-hosted style for AWS itself, path style for everything
+	// else, which is what S3-compatible services expect.
+	var host, uri string
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		host = u.Host
+		uri = u.Scheme + "://" + host
+		key = s.bucket + "/" + key
+	} else {
+		host = s.bucket + ".s3." + region + ".amazonaws.com"
+		uri = "https://" + host
+	}
+
+	canonicalPath := "/" + awsEscape(key, false)
+	canonicalQuery := awsQuery(query)
+
+	req, err := http.NewRequest(method, uri+canonicalPath+"?"+canonicalQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := hex.EncodeToString(sha256.New().Sum(nil))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	signer := awsSigner{accessKey: accessKey, secretKey: secretKey, region: region, service: "s3"}
+	signer.sign(req, host, canonicalPath, canonicalQuery, payloadHash, time.Now())
+
+	return doHTTP(req)
+}
+
+// An awsSigner signs requests with AWS Signature Version 4.
+type awsSigner struct {
+	accessKey, secretKey string
+	region, service      string
+}
+
+// sign sets req's X-Amz-Date and Authorization headers for a request to
+// host made at now, signing the host header and every X-Amz- one. path
+// and query are the canonical forms of the ones req is sent with.
+func (s awsSigner) sign(req *http.Request, host, path, query, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = req.Header.Get(name)
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		query,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + s.service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signature := hex.EncodeToString(hmacSHA256(s.signingKey(date), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// signingKey derives the key that signs the day's requests.
+func (s awsSigner) signingKey(date string) []byte {
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes s the way SigV4 wants: everything but
+// unreserved characters, and slashes too unless it's a path.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsQuery returns the canonical, sorted form of a query string.
+func awsQuery(query url.Values) string {
+	var parts []string
+	for key, values := range query {
+		for _, value := range values {
+			parts = append(parts, awsEscape(key, true)+"="+awsEscape(value, true))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The requests are from AWS's Signature Version 4 test suite, which
+// signs them all with these credentials at this time.
+func TestAWSSign(t *testing.T) {
+	signer := awsSigner{
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "us-east-1",
+		service:   "service",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	emptyHash := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		query  url.Values
+		want   string
+	}{
+		{
+			name:   "get-vanilla",
+			method: "GET",
+			path:   "",
+			want:   "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:   "post-vanilla",
+			method: "POST",
+			path:   "",
+			want:   "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:   "get-vanilla-empty-query-key",
+			method: "GET",
+			path:   "",
+			query:  url.Values{"Param1": {"value1"}},
+			want:   "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb",
+		},
+		{
+			name:   "get-vanilla-query-order-key-case",
+			method: "GET",
+			path:   "",
+			query:  url.Values{"Param2": {"value2"}, "Param1": {"value1"}},
+			want:   "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:   "get-vanilla-query-order-key",
+			method: "GET",
+			path:   "",
+			query:  url.Values{"Param1": {"value2", "Value1"}},
+			want:   "eedbc4e291e521cf13422ffca22be7d2eb8146eecf653089df300a15b2382bd1",
+		},
+		{
+			name:   "get-unreserved",
+			method: "GET",
+			path:   "-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+			want:   "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f",
+		},
+		{
+			name:   "get-space",
+			method: "GET",
+			path:   "example space/",
+			want:   "652487583200325589f1fba4c7e578f72c47cb61beeca81406b39ddec1366741",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, query := "/"+awsEscape(tt.path, false), awsQuery(tt.query)
+			req, err := http.NewRequest(tt.method, "https://example.amazonaws.com"+path+"?"+query, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer.sign(req, "example.amazonaws.com", path, query, emptyHash, now)
+
+			if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+				t.Errorf("X-Amz-Date = %q, want 20150830T123600Z", got)
+			}
+			auth := req.Header.Get("Authorization")
+			want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=" + tt.want
+			if auth != want {
+				t.Errorf("Authorization =\n%s\nwant\n%s", auth, want)
+			}
+		})
+	}
+}
+
+// The key and its derivation are the example in AWS's documentation.
+func TestAWSSigningKey(t *testing.T) {
+	signer := awsSigner{secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", region: "us-east-1", service: "iam"}
+	got := hex.EncodeToString(signer.signingKey("20120215"))
+	want := "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d"
+	if got != want {
+		t.Errorf("signingKey = %s, want %s", got, want)
+	}
+}
+
+func TestAWSEscape(t *testing.T) {
+	tests := []struct {
+		s           string
+		escapeSlash bool
+		want        string
+	}{
+		{"docs/a b.pdf", false, "docs/a%20b.pdf"},
+		{"docs/a b.pdf", true, "docs%2Fa%20b.pdf"},
+		{"résumé+1.pdf", false, "r%C3%A9sum%C3%A9%2B1.pdf"},
+		{"a~b_c-d.e", true, "a~b_c-d.e"},
+	}
+	for _, tt := range tests {
+		if got := awsEscape(tt.s, tt.escapeSlash); got != tt.want {
+			t.Errorf("awsEscape(%q, %v) = %q, want %q", tt.s, tt.escapeSlash, got, tt.want)
+		}
+	}
+
+	query := url.Values{"prefix": {"papers/2024 "}, "list-type": {"2"}, "continuation-token": {"a+b="}}
+	want := strings.Join([]string{"continuation-token=a%2Bb%3D", "list-type=2", "prefix=papers%2F2024%20"}, "&")
+	if got := awsQuery(query); got != want {
+		t.Errorf("awsQuery = %q, want %q", got, want)
+	}
+}
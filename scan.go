@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sniffing modes, for walker.sniff.
+const (
+	sniffNone          = "none"
+	sniffExtensionless = "extensionless"
+	sniffAll           = "all"
+)
+
+// A walker finds candidate documents in directory trees.
+type walker struct {
+	followSymlinks bool
+
+	// excludes are glob patterns for paths to skip. See excluded.
+	excludes []string
+
+	// If there are any match patterns, documents must match one of them;
+	// documents matching a noMatch pattern are left out. Both are matched
+	// against the document's whole path.
+	match, noMatch []*regexp.Regexp
+
+	// If there are any tags, documents must have one of them, from their
+	// source or given with randpage tag and kept in st. Documents banned
+	// or snoozed in st are left out, and so are the ones marked done
+	// unless includeDone is set.
+	tags        []string
+	st          *state
+	includeDone bool
+
+	// includeHidden includes dotfiles and dot directories, which are
+	// skipped by default.
+	includeHidden bool
+
+	// sniff says which files are identified by their content rather than
+	// their name: none, only those without an extension, or all of them.
+	sniff string
+
+	// minSize and maxSize bound the size of documents, in bytes. Zero
+	// means no bound.
+	minSize, maxSize int64
+
+	// newerThan and olderThan bound the modification time of documents.
+	// The zero time means no bound.
+	newerThan, olderThan time.Time
+
+	// minPages and maxPages bound the page count of documents. Zero means
+	// no bound. Counting pages means opening each document, so counts are
+	// kept in pages.
+	minPages, maxPages int
+	pages              *pageCounts
+
+	// priorities weight the choice of documents, balance is how they're
+	// balanced across roots or directories, and quotas divide the picks
+	// among categories of them.
+	priorities *priorities
+	balance    string
+	quotas     *quotas
+
+	// preferUnopened favors documents that have never been picked, and
+	// recentBias, if set, the ones modified recently: as of recentAsOf,
+	// or now if it's zero.
+	preferUnopened bool
+	recentBias     time.Duration
+	recentAsOf     time.Time
+
+	// maxDepth limits how many directories deep the walk goes below each
+	// root; files directly in a root are at depth 1. Zero means no limit.
+	maxDepth int
+
+	// jobs is how many directories are read at once.
+	jobs int
+
+	// rescan reads every directory, rather than trusting the index for
+	// the ones that haven't changed.
+	rescan bool
+
+	// dirTimeout and fileTimeout limit how long listing a directory and
+	// reading a file (to sniff it, list an archive, or count its pages)
+	// can take before it's given up on. Zero means no limit.
+	dirTimeout, fileTimeout time.Duration
+
+	// timeouts are the paths that were given up on.
+	timeoutsMu sync.Mutex
+	timeouts   []string
+
+	// stale holds directories to read even if the index has them as
+	// unchanged.
+	stale map[string]bool
+}
+
+// walk finds the candidates under root. Directories are read by up to
+// jobs goroutines at once, which makes a big difference on network
+// filesystems, where most of the time is spent waiting. What's found is
+// kept in an index, so unchanged directories aren't read next time.
+func (w *walker) walk(root string) []candidate {
+	ignores := loadIgnores(root)
+	t := &walk{walker: w, root: root, ignores: ignores, sem: make(chan struct{}, max(w.jobs-1, 0)), visited: make(map[string]bool)}
+
+	info, err := os.Lstat(root)
+	if err == nil && info.Mode()&fs.ModeSymlink != 0 && w.followSymlinks {
+		info, err = os.Stat(root)
+	}
+	if err != nil {
+		slog.Info("walking", "path", root, "err", err)
+		return nil
+	}
+
+	switch {
+	case info.IsDir():
+		t.index = loadIndex(w, root, ignores, w.rescan)
+		t.dir(root)
+	case info.Mode().IsRegular():
+		t.add(t.docs(root, info))
+	}
+	t.wg.Wait()
+
+	if t.index != nil {
+		if err := t.index.save(); err != nil {
+			slog.Info("saving index", "path", t.index.path, "err", err)
+		}
+	}
+
+	// Goroutines finish in any order; keep the results stable.
+	sort.Slice(t.found, func(i, j int) bool {
+		return t.found[i].String() < t.found[j].String()
+	})
+
+	return t.found
+}
+
+// A walk is the state of one walker.walk.
+type walk struct {
+	*walker
+	root    string
+	ignores ignoreList
+	index   *index
+
+	// sem holds a token for each goroutine reading a directory, besides
+	// the one that started the walk.
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu    sync.Mutex
+	found []candidate
+
+	// visited holds the resolved paths of directories already walked, so
+	// symlink loops are only followed once.
+	visited map[string]bool
+}
+
+func (t *walk) add(docs []candidate) {
+	t.mu.Lock()
+	t.found = append(t.found, docs...)
+	t.mu.Unlock()
+}
+
+// dir walks the directory at path, which is root, a directory under it,
+// or a symlink to one. Exclusions are always relative to root.
+func (t *walk) dir(path string) {
+	if t.maxDepth > 0 && path != t.root && depth(t.root, path) >= t.maxDepth {
+		return
+	}
+	if t.followSymlinks && t.seen(path) {
+		return
+	}
+
+	info, err := withTimeout(t.dirTimeout, func() (fs.FileInfo, error) { return os.Stat(path) })
+	if err != nil {
+		t.failed(path, err)
+		return
+	}
+
+	var found indexDir
+	ok := false
+	if !t.stale[path] {
+		found, ok = t.index.lookup(path, info.ModTime())
+	}
+	if !ok {
+		var complete bool
+		found, complete, err = t.read(path)
+		if err != nil {
+			t.failed(path, err)
+			return
+		}
+		found.ModTime = info.ModTime()
+
+		// Files that timed out would be missing from the index for good.
+		if complete {
+			t.index.put(path, found)
+		}
+	}
+
+	t.add(found.Docs)
+	for _, sub := range found.Dirs {
+		t.spawn(sub)
+	}
+}
+
+// failed logs an error reading path, and notes it if it's a timeout.
+func (t *walk) failed(path string, err error) {
+	if errors.Is(err, errTimeout) {
+		t.timedOut(path)
+	}
+	slog.Info("reading", "path", path, "err", err)
+}
+
+// read lists the documents and subdirectories in the directory at path,
+// reporting whether every file could be read in time.
+func (t *walk) read(path string) (indexDir, bool, error) {
+	var ret indexDir
+
+	entries, err := withTimeout(t.dirTimeout, func() ([]fs.DirEntry, error) { return os.ReadDir(path) })
+	if err != nil {
+		return ret, false, err
+	}
+
+	complete := true
+	for _, d := range entries {
+		p := filepath.Join(path, d.Name())
+		if !t.includeHidden && isHidden(d.Name()) || t.excluded(t.root, p, d.IsDir()) || t.ignores.ignored(relSlash(t.root, p), d.IsDir()) {
+			continue
+		}
+
+		if d.IsDir() {
+			ret.Dirs = append(ret.Dirs, p)
+			continue
+		}
+
+		f, err := withTimeout(t.fileTimeout, func() (indexDir, error) { return t.file(p, d) })
+		if err != nil {
+			t.failed(p, err)
+			complete = complete && !errors.Is(err, errTimeout)
+			continue
+		}
+		ret.Dirs = append(ret.Dirs, f.Dirs...)
+		ret.Docs = append(ret.Docs, f.Docs...)
+	}
+
+	return ret, complete, nil
+}
+
+// file returns what's in the directory entry d at path, which isn't a
+// directory itself but may be a symlink to one.
+func (t *walk) file(path string, d fs.DirEntry) (indexDir, error) {
+	var ret indexDir
+
+	var info fs.FileInfo
+	var err error
+	switch {
+	case d.Type()&fs.ModeSymlink != 0:
+		if !t.followSymlinks {
+			return ret, nil
+		}
+		if info, err = os.Stat(path); err != nil {
+			return ret, err
+		}
+		if info.IsDir() {
+			ret.Dirs = []string{path}
+			return ret, nil
+		}
+	case d.Type().IsRegular():
+		if info, err = d.Info(); err != nil {
+			return ret, err
+		}
+	default:
+		return ret, nil
+	}
+
+	if info.Mode().IsRegular() {
+		ret.Docs = t.docs(path, info)
+	}
+	return ret, nil
+}
+
+// docs returns the candidates in the regular file at path, noting the
+// file's size and modification time on it if it's a document itself.
+func (t *walk) docs(path string, info fs.FileInfo) []candidate {
+	ret := t.fileCandidates(path)
+	for i := range ret {
+		if ret[i].Path == path && ret[i].Member == "" {
+			ret[i].Size = info.Size()
+			ret[i].ModTime = info.ModTime()
+		}
+	}
+	return ret
+}
+
+// spawn walks the directory at path in a new goroutine if there's a token
+// for one, and in this one otherwise.
+func (t *walk) spawn(path string) {
+	select {
+	case t.sem <- struct{}{}:
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			defer func() { <-t.sem }()
+			t.dir(path)
+		}()
+	default:
+		t.dir(path)
+	}
+}
+
+// filter returns the documents that pass the walker's filters on their
+// properties.
+func (w *walker) filter(docs []candidate) []candidate {
+	var ret []candidate
+	for _, doc := range docs {
+		if w.keep(doc) {
+			ret = append(ret, doc)
+		}
+	}
+	return ret
+}
+
+// keep reports whether doc passes the walker's filters. A local file's
+// size and modification time are looked up if its source didn't list
+// them; documents with properties that aren't known otherwise pass. Only
+// local documents are counted; see pageRange.
+func (w *walker) keep(doc candidate) bool {
+	if !w.matches(doc) {
+		return false
+	}
+	if len(w.tags) > 0 && !w.st.tagged(doc, w.tags) {
+		return false
+	}
+	if w.st.Banned[doc.key()] || w.st.snoozed(doc, time.Now()) {
+		return false
+	}
+	if _, done := w.st.Done[doc.key()]; done && !w.includeDone {
+		return false
+	}
+
+	// Documents that couldn't be read before are skipped until they
+	// change. The walk notes their size and modification time.
+	if !doc.remote() && doc.Size != 0 {
+		if reason, ok := w.pages.failed(doc); ok {
+			slog.Info("skipping document that failed before", "path", doc, "err", reason)
+			return false
+		}
+	}
+
+	countPages := w.minPages > 0 || w.maxPages > 0
+	if w.minSize == 0 && w.maxSize == 0 && w.newerThan.IsZero() && w.olderThan.IsZero() && !countPages {
+		return true
+	}
+
+	doc = statLocal(doc)
+
+	if doc.Size != 0 && (doc.Size < w.minSize || w.maxSize != 0 && doc.Size > w.maxSize) {
+		return false
+	}
+	if !doc.ModTime.IsZero() && (doc.ModTime.Before(w.newerThan) || !w.olderThan.IsZero() && doc.ModTime.After(w.olderThan)) {
+		return false
+	}
+
+	if countPages && !doc.remote() {
+		n, err := w.pages.count(doc, w.fileTimeout)
+		if errors.Is(err, errTimeout) {
+			w.timedOut(doc.String())
+		}
+		if err != nil {
+			slog.Info("counting pages", "path", doc, "err", err)
+			return false
+		}
+		return w.pageRange(n)
+	}
+
+	return true
+}
+
+// matches reports whether doc's path passes the walker's match and noMatch
+// patterns.
+func (w *walker) matches(doc candidate) bool {
+	p := doc.String()
+
+	for _, re := range w.noMatch {
+		if re.MatchString(p) {
+			return false
+		}
+	}
+
+	if len(w.match) == 0 {
+		return true
+	}
+	for _, re := range w.match {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *walker) timedOut(path string) {
+	w.timeoutsMu.Lock()
+	w.timeouts = append(w.timeouts, path)
+	w.timeoutsMu.Unlock()
+}
+
+// pageRange reports whether a document of n pages is within the walker's
+// page count bounds. Remote documents are only counted once they're
+// picked and downloaded, so this is checked again then.
+func (w *walker) pageRange(n int) bool {
+	return n >= w.minPages && (w.maxPages == 0 || n <= w.maxPages)
+}
+
+var errTimeout = errors.New("timed out")
+
+// withTimeout returns f's result, or errTimeout if it takes longer than d.
+// Filesystem calls can't be interrupted, so f carries on in the background
+// after a timeout: it mustn't touch anything the caller goes on to use.
+func withTimeout[T any](d time.Duration, f func() (T, error)) (T, error) {
+	if d == 0 {
+		return f()
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := f()
+		done <- result{v, err}
+	}()
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-timer.C:
+		var zero T
+		return zero, errTimeout
+	}
+}
+
+// skipRemote reports whether a path found on a remote source, below the
+// source's root path, should be left out.
+func (w *walker) skipRemote(root, p string, isDir bool) bool {
+	p = strings.TrimSuffix(p, "/")
+	if !w.includeHidden && isHidden(path.Base(p)) {
+		return true
+	}
+	return w.excluded(root, p, isDir)
+}
+
+// keepRemote reports whether p is a document the walker's options allow,
+// for sources that list a whole tree at once rather than a directory at a
+// time. Each directory between root and p is checked along with p itself.
+func (w *walker) keepRemote(root, p string) bool {
+	if !looksLikeDocument(path.Base(p)) {
+		return false
+	}
+
+	dirs := strings.Split(strings.Trim(strings.TrimPrefix(path.Dir(p), root), "/"), "/")
+	if dirs[0] == "" {
+		dirs = nil
+	}
+	if w.maxDepth > 0 && len(dirs) >= w.maxDepth {
+		return false
+	}
+
+	dir := root
+	for _, elem := range dirs {
+		dir += "/" + elem
+		if w.skipRemote(root, dir, true) {
+			return false
+		}
+	}
+
+	return !w.skipRemote(root, p, false)
+}
+
+// excluded reports whether path, found while walking root, matches one of
+// the exclude patterns. Patterns are matched against both the base name and
+// the path relative to root, so "receipts/" and "2019/*.pdf" both work. A
+// pattern ending in a slash only matches directories. Patterns use
+// forward slashes, whatever the platform's separator.
+func (w *walker) excluded(root, p string, isDir bool) bool {
+	rel := relSlash(root, p)
+	name := filepath.Base(p)
+
+	for _, pattern := range w.excludes {
+		if strings.HasSuffix(pattern, "/") {
+			if !isDir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hasHiddenElem reports whether any element of a slash-separated path is
+// hidden. __MACOSX is hidden in spirit.
+func hasHiddenElem(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if isHidden(elem) || elem == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// relSlash returns path relative to root, with forward slashes.
+func relSlash(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// depth returns how many levels below root path is.
+func depth(root, path string) int {
+	return strings.Count(relSlash(root, path), "/") + 1
+}
+
+// seen reports whether the directory at path has already been walked,
+// marking it as walked if not.
+func (t *walk) seen(path string) bool {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	if real, err = filepath.Abs(real); err != nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.visited[real] {
+		return true
+	}
+	t.visited[real] = true
+
+	return false
+}
+
+// fileCandidates returns the candidates in a regular file: the file itself
+// if it's a document, its contents if it's an archive, or what it points
+// to if it's a shortcut.
+func (w *walker) fileCandidates(path string) []candidate {
+	name := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(name))
+
+	if w.sniff == sniffAll || w.sniff == sniffExtensionless && ext == "" {
+		if format := sniffFormat(path); format != "" {
+			return []candidate{{Path: path, Format: format}}
+		}
+
+		// A .pdf that doesn't look like one isn't worth trying. Other
+		// extensions are formats sniffing can't vouch for either way.
+		if sniffable[ext] {
+			return nil
+		}
+	}
+
+	if looksLikeDocument(name) {
+		return []candidate{{Path: path}}
+	}
+
+	if isShortcut(name) {
+		doc, err := shortcutCandidate(path)
+		if err != nil {
+			slog.Info("reading shortcut", "path", path, "err", err)
+			return nil
+		}
+		return []candidate{doc}
+	}
+
+	if looksLikeArchive(name) {
+		docs, err := archiveCandidates(path)
+		if err != nil {
+			slog.Info("reading archive", "path", path, "err", err)
+			return nil
+		}
+
+		if w.includeHidden {
+			return docs
+		}
+
+		// Archives made on macOS are full of __MACOSX/._*.pdf resource
+		// forks; leave those out along with other hidden members.
+		var ret []candidate
+		for _, doc := range docs {
+			if !hasHiddenElem(doc.Member) {
+				ret = append(ret, doc)
+			}
+		}
+		return ret
+	}
+
+	return nil
+}
+
+// readLines reads document paths or urls from r, one per line, or separated
+// by NUL bytes if null is set.
+func readLines(r io.Reader, null bool) []candidate {
+	var ret []candidate
+
+	scanner := bufio.NewScanner(r)
+	if null {
+		scanner.Split(scanNull)
+	}
+	for scanner.Scan() {
+		if schemeOf(scanner.Text()) != "" || looksLikeDocument(scanner.Text()) {
+			ret = append(ret, candidate{Path: scanner.Text()})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		slog.Error("reading lines", slog.Any("err", err))
+	}
+
+	return ret
+}
+
+// scanNull is a bufio.SplitFunc for NUL-terminated records, as written by
+// find -print0.
+func scanNull(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
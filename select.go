@@ -0,0 +1,343 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+// A selector is a strategy for choosing documents, named by --strategy.
+type selector interface {
+	// order returns docs in the order to try them.
+	order(docs []candidate, w *walker, st *state, rnd *rand.Rand) []candidate
+}
+
+// Selection strategies, for --strategy.
+const (
+	// strategyUniform gives every document the same chance.
+	strategyUniform = "uniform"
+
+	// strategyPages weights documents by their page count, so every page
+	// in the library is equally likely.
+	strategyPages = "pages"
+
+	// strategyShort weights documents by the inverse of their page count,
+	// favoring the ones that can be finished in a sitting.
+	strategyShort = "short"
+
+	// strategyCoverage weights documents by the pages in them that
+	// haven't been seen, so every page in the library comes up once
+	// before any comes up again.
+	strategyCoverage = "coverage"
+
+	// strategyQueue deals documents from a shuffled queue kept between
+	// runs, so each comes up once before any comes up again.
+	strategyQueue = "queue"
+
+	// strategySpaced tries the documents on the revisiting schedule
+	// first, the most overdue first, and then the rest uniformly.
+	strategySpaced = "spaced"
+)
+
+// selectors are the strategies by name.
+var selectors = map[string]selector{
+	strategyUniform:  pageWeighted{},
+	strategyPages:    pageWeighted{func(doc candidate, pages int) float64 { return float64(pages) }},
+	strategyShort:    pageWeighted{func(doc candidate, pages int) float64 { return 1 / float64(pages) }},
+	strategyCoverage: coverage{},
+	strategySpaced:   spaced{},
+	strategyQueue:    queue{},
+}
+
+// selectorNames returns the names of the strategies, sorted.
+func selectorNames() []string {
+	names := make([]string, 0, len(selectors))
+	for name := range selectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Balancing, for --balance.
+const (
+	// balanceNone weighs every document on its own.
+	balanceNone = "none"
+
+	// balanceRoots gives each root the same chance, however many
+	// documents are in it.
+	balanceRoots = "roots"
+
+	// balanceDirs gives each directory the same chance.
+	balanceDirs = "dirs"
+)
+
+// unopenedBoost multiplies the weight of documents that have never been
+// opened, with --prefer-unopened.
+const unopenedBoost = 10
+
+// dismissPenalty multiplies the weight of a document for each time it
+// was dismissed.
+const dismissPenalty = 0.5
+
+// ratingBoost multiplies the weight of a document for each point its
+// rating is above 3, and divides it for each point below.
+const ratingBoost = 2
+
+// recentBoost multiplies the weight of a document modified just now, with
+// --recent-bias. The boost halves with every span of the bias since.
+const recentBoost = 10
+
+// pageWeighted weights documents by their page count with weigh, or
+// gives them all the same weight if it's nil.
+type pageWeighted struct {
+	weigh func(doc candidate, pages int) float64
+}
+
+func (s pageWeighted) order(docs []candidate, w *walker, st *state, rnd *rand.Rand) []candidate {
+	if s.weigh == nil {
+		// No need to count pages.
+		return weightedOrder(docs, nil, w, st, rnd)
+	}
+	return weightedOrder(docs, pageWeights(docs, w, s.weigh), w, st, rnd)
+}
+
+// coverage weights documents by their unseen pages, within their front
+// and back matter. Picking each page uniformly from the ones left walks
+// through the library in a random order, as if every page had been
+// shuffled into one queue, without having to keep it.
+type coverage struct{}
+
+func (coverage) order(docs []candidate, w *walker, st *state, rnd *rand.Rand) []candidate {
+	weigh := func(doc candidate, pages int) float64 {
+		first, last := matter.bounds(doc, pages)
+		return float64(st.unseen(doc, first, last))
+	}
+
+	weights := pageWeights(docs, w, weigh)
+	if len(docs) > 0 && !slices.ContainsFunc(weights, func(f float64) bool { return f > 0 }) {
+		// Every page has been seen: start over.
+		st.Seen = nil
+		st.touch(kindSeen, "")
+		weights = pageWeights(docs, w, weigh)
+	}
+	return weightedOrder(docs, weights, w, st, rnd)
+}
+
+// spaced puts the documents on st's revisiting schedule first, by when
+// they're due.
+type spaced struct{}
+
+func (spaced) order(docs []candidate, w *walker, st *state, rnd *rand.Rand) []candidate {
+	docs = weightedOrder(docs, nil, w, st, rnd)
+	sort.SliceStable(docs, func(i, j int) bool {
+		a, aok := st.Cards[docs[i].key()]
+		b, bok := st.Cards[docs[j].key()]
+		if aok && bok {
+			return a.Due.Before(b.Due)
+		}
+		return aok
+	})
+	return docs
+}
+
+// queue deals documents in the order of st's queue, starting it over
+// once every document has been dealt. Documents added since it was
+// shuffled are shuffled into what's left of it.
+type queue struct{}
+
+func (queue) order(docs []candidate, w *walker, st *state, rnd *rand.Rand) []candidate {
+	byKey := make(map[string]candidate, len(docs))
+	for _, doc := range docs {
+		byKey[doc.key()] = doc
+	}
+
+	// Forget the documents that are gone.
+	var kept []string
+	dealt := 0
+	inQueue := make(map[string]bool)
+	for i, key := range st.Queue {
+		if _, ok := byKey[key]; ok && !inQueue[key] {
+			kept = append(kept, key)
+			inQueue[key] = true
+			if i < st.Dealt {
+				dealt++
+			}
+		}
+	}
+	st.Queue, st.Dealt = kept, dealt
+
+	if st.Dealt >= len(st.Queue) {
+		st.Queue, st.Dealt = nil, 0
+		for _, doc := range weightedOrder(docs, nil, w, st, rnd) {
+			st.Queue = append(st.Queue, doc.key())
+		}
+	} else {
+		for _, doc := range docs {
+			if !inQueue[doc.key()] {
+				i := st.Dealt + rnd.Intn(len(st.Queue)-st.Dealt+1)
+				st.Queue = slices.Insert(st.Queue, i, doc.key())
+			}
+		}
+	}
+
+	// The documents already dealt only come up if nothing else opens.
+	ret := make([]candidate, 0, len(st.Queue))
+	for _, key := range append(slices.Clone(st.Queue[st.Dealt:]), st.Queue[:st.Dealt]...) {
+		ret = append(ret, byKey[key])
+	}
+	return ret
+}
+
+// weightedOrder returns docs in a random permutation, where each
+// document's chance of coming before the others is proportional to its
+// weight (all the same if weights is nil), balanced across its root or
+// directory and scaled to fill the quotas from st's history, times its
+// priority (and unopenedBoost for documents st has never picked, if the
+// walker prefers them, recentBoost for new ones, dismissPenalty for each
+// time one was dismissed, and ratingBoost for its rating).
+func weightedOrder(docs []candidate, weights []float64, w *walker, st *state, rnd *rand.Rand) []candidate {
+	prio := make([]float64, len(docs))
+	uniform := weights == nil && w.balance == balanceNone && len(w.quotas.categories) == 0
+	opened := st.opened()
+	now := time.Now()
+	if !w.recentAsOf.IsZero() {
+		now = w.recentAsOf
+	}
+	for i, doc := range docs {
+		prio[i] = w.priorities.weight(doc)
+		if w.preferUnopened && !opened[doc.key()] {
+			prio[i] *= unopenedBoost
+		}
+		if n := st.Dismissals[doc.key()]; n > 0 {
+			prio[i] *= math.Pow(dismissPenalty, float64(n))
+		}
+		if r, ok := st.Ratings[doc.key()]; ok {
+			prio[i] *= math.Pow(ratingBoost, float64(r-3))
+		}
+		if w.recentBias > 0 && !doc.ModTime.IsZero() {
+			halvings := float64(now.Sub(doc.ModTime)) / float64(w.recentBias)
+			prio[i] *= 1 + (recentBoost-1)*math.Exp2(-max(0, halvings))
+		}
+		uniform = uniform && prio[i] == 1
+	}
+
+	if uniform {
+		rnd.Shuffle(len(docs), func(i, j int) {
+			docs[i], docs[j] = docs[j], docs[i]
+		})
+		return docs
+	}
+
+	if weights == nil {
+		weights = make([]float64, len(docs))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+
+	balance(docs, weights, w.balance)
+	w.quotas.scale(docs, weights, st)
+
+	for i := range weights {
+		weights[i] *= prio[i]
+	}
+	return weightedShuffle(docs, weights, rnd)
+}
+
+// balance scales weights so the documents in each group (root or
+// directory, by mode) add up to the same weight, as if a group were
+// picked first and then a document in it.
+func balance(docs []candidate, weights []float64, mode string) {
+	group := func(doc candidate) string { return doc.Root }
+	switch mode {
+	case balanceNone:
+		return
+	case balanceDirs:
+		group = func(doc candidate) string { return path.Dir(filepath.ToSlash(doc.String())) }
+	}
+
+	totals := make(map[string]float64)
+	for i, doc := range docs {
+		totals[group(doc)] += weights[i]
+	}
+	for i, doc := range docs {
+		if total := totals[group(doc)]; total > 0 {
+			weights[i] /= total
+		}
+	}
+}
+
+// pageWeights returns the weight of each document given its page count.
+// Remote documents can't be counted without downloading them, so they get
+// the average.
+func pageWeights(docs []candidate, w *walker, weigh func(doc candidate, pages int) float64) []float64 {
+	weights := make([]float64, len(docs))
+
+	var total float64
+	var counted int
+	for i, doc := range docs {
+		if doc.remote() {
+			continue
+		}
+
+		n, err := w.pages.count(statLocal(doc), w.fileTimeout)
+		if err != nil {
+			slog.Info("counting pages", "path", doc, "err", err)
+			continue
+		}
+		if n == 0 {
+			continue
+		}
+		weights[i] = weigh(doc, n)
+		total += weights[i]
+		counted++
+	}
+
+	mean := 1.0
+	if counted > 0 {
+		mean = total / float64(counted)
+	}
+	for i, doc := range docs {
+		if doc.remote() {
+			weights[i] = mean
+		}
+	}
+
+	return weights
+}
+
+// weightedShuffle orders docs by weighted random sampling without
+// replacement (Efraimidis and Spirakis): each document gets the key
+// u^(1/weight) for a uniform random u, and the highest keys go first.
+// Documents with no weight go last.
+func weightedShuffle(docs []candidate, weights []float64, rnd *rand.Rand) []candidate {
+	keys := make([]float64, len(docs))
+	for i, weight := range weights {
+		if weight > 0 {
+			// Compared as logarithms, which don't underflow.
+			keys[i] = math.Log(rnd.Float64()) / weight
+		} else {
+			keys[i] = math.Inf(-1)
+		}
+	}
+
+	idx := make([]int, len(docs))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return keys[idx[a]] > keys[idx[b]]
+	})
+
+	ret := make([]candidate, len(docs))
+	for i, j := range idx {
+		ret[i] = docs[j]
+	}
+	return ret
+}
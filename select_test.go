@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestQueueOrder(t *testing.T) {
+	docs := func(names ...string) []candidate {
+		var ret []candidate
+		for _, name := range names {
+			ret = append(ret, candidate{Path: "/lib/" + name + ".pdf"})
+		}
+		return ret
+	}
+	keys := func(names ...string) []string {
+		var ret []string
+		for _, doc := range docs(names...) {
+			ret = append(ret, doc.key())
+		}
+		return ret
+	}
+
+	tests := []struct {
+		name  string
+		queue []string
+		dealt int
+		docs  []candidate
+
+		// want checks the queue and dealt count order leaves, and the
+		// documents it returns.
+		want func(t *testing.T, queue []string, dealt int, got []string)
+	}{
+		{
+			name:  "forgets documents that are gone, and repeats",
+			queue: keys("a", "gone", "b", "a", "c"),
+			dealt: 2,
+			docs:  docs("c", "b", "a"),
+			want: func(t *testing.T, queue []string, dealt int, got []string) {
+				if !slices.Equal(queue, keys("a", "b", "c")) || dealt != 1 {
+					t.Errorf("queue = %q dealt %d, want %q dealt 1", queue, dealt, keys("a", "b", "c"))
+				}
+				if !slices.Equal(got, keys("b", "c", "a")) {
+					t.Errorf("order = %q, want %q", got, keys("b", "c", "a"))
+				}
+			},
+		},
+		{
+			name:  "inserts new documents into what's left to deal",
+			queue: keys("a", "b", "c"),
+			dealt: 1,
+			docs:  docs("a", "b", "c", "d"),
+			want: func(t *testing.T, queue []string, dealt int, got []string) {
+				if len(queue) != 4 || queue[0] != keys("a")[0] || dealt != 1 {
+					t.Fatalf("queue = %q dealt %d, want a dealt first and 4 in all", queue, dealt)
+				}
+				rest := slices.DeleteFunc(slices.Clone(queue[1:]), func(key string) bool { return key == keys("d")[0] })
+				if !slices.Equal(rest, keys("b", "c")) {
+					t.Errorf("queue = %q, want b before c, with d among them", queue)
+				}
+				if got[len(got)-1] != keys("a")[0] {
+					t.Errorf("order = %q, want a last", got)
+				}
+			},
+		},
+		{
+			name:  "deals again once every document has been dealt",
+			queue: keys("a", "b"),
+			dealt: 2,
+			docs:  docs("a", "b", "c"),
+			want: func(t *testing.T, queue []string, dealt int, got []string) {
+				if dealt != 0 || len(queue) != 3 {
+					t.Fatalf("queue = %q dealt %d, want all 3 undealt", queue, dealt)
+				}
+				sorted := slices.Clone(queue)
+				slices.Sort(sorted)
+				if !slices.Equal(sorted, keys("a", "b", "c")) {
+					t.Errorf("queue = %q, want a, b, and c", queue)
+				}
+				if !slices.Equal(got, queue) {
+					t.Errorf("order = %q, want the queue %q", got, queue)
+				}
+			},
+		},
+		{
+			name:  "starts over when nothing in it is left",
+			queue: keys("gone"),
+			docs:  docs("a"),
+			want: func(t *testing.T, queue []string, dealt int, got []string) {
+				if !slices.Equal(queue, keys("a")) || dealt != 0 {
+					t.Errorf("queue = %q dealt %d, want %q dealt 0", queue, dealt, keys("a"))
+				}
+			},
+		},
+	}
+
+	prio, err := newPriorities(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quotas, err := newQuotas(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &walker{priorities: prio, quotas: quotas}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &state{Queue: tt.queue, Dealt: tt.dealt}
+			var got []string
+			for _, doc := range (queue{}).order(tt.docs, w, st, rand.New(rand.NewSource(1))) {
+				got = append(got, doc.key())
+			}
+			tt.want(t, st.Queue, st.Dealt, got)
+		})
+	}
+}
+
+func TestQueuePicked(t *testing.T) {
+	doc := func(name string) candidate { return candidate{Path: "/lib/" + name + ".pdf"} }
+	key := func(name string) string { return doc(name).key() }
+
+	st := &state{Queue: []string{key("a"), key("b"), key("c")}}
+	st.picked(pick{Doc: doc("c"), Page: 1})
+	if want := []string{key("c"), key("a"), key("b")}; !slices.Equal(st.Queue, want) || st.Dealt != 1 {
+		t.Errorf("after picking c: queue = %q dealt %d, want %q dealt 1", st.Queue, st.Dealt, want)
+	}
+
+	// Undoing it deals it back.
+	st.undo()
+	if st.Dealt != 0 {
+		t.Errorf("after undoing: dealt %d, want 0", st.Dealt)
+	}
+}
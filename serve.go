@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// serve runs handler on a temporary web server and points the viewer at
+// name, with an optional url fragment. It blocks until the handler closes
+// done, which it should do once the document has been transferred.
+func serve(handler http.Handler, name, fragment string, done <-chan struct{}) error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		slog.Error("listening", "err", err)
+		return err
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/%s", port, (&url.URL{Path: name}).EscapedPath())
+	if fragment != "" {
+		url += "#" + fragment
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slog.Info("http request", "method", r.Method, "path", r.URL.Path)
+			handler.ServeHTTP(w, r)
+		}),
+	}
+
+	go srv.Serve(ln)
+
+	page := 1
+	if s, ok := strings.CutPrefix(fragment, "page="); ok {
+		page, _ = strconv.Atoi(s)
+	}
+	viewers, detach, err := urlViewers(url, page)
+	if err != nil {
+		return err
+	}
+
+	// Fall back on the next viewer when one fails.
+	for i, viewer := range viewers {
+		if err = runViewer(viewer, detach[i], done); err == nil {
+			return nil
+		}
+		slog.Error("executing viewer", "viewer", viewer[0], "url", url, "err", err)
+	}
+	return viewerError(err)
+}
+
+// runViewer runs the viewer command args, then waits for done. With
+// detach, it doesn't wait for the viewer to exit, but fails if it does
+// with an error before done.
+func runViewer(args []string, detach bool, done <-chan struct{}) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	if !detach {
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		<-done
+		return nil
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	select {
+	case <-done:
+	case err := <-exited:
+		if err != nil {
+			return err
+		}
+		<-done
+	}
+	return nil
+}
+
+// splitCommand splits a command line into words, honoring single and
+// double quotes and backslash escapes, so config settings like
+// `open -a "Google Chrome"` work.
+func splitCommand(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == '\'':
+			word.WriteRune(r)
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	return words, nil
+}
+
+// serveBytes serves buf as a single document called name, returning once
+// it has been transferred.
+func serveBytes(name, contentType string, buf []byte, fragment string) error {
+	done := make(chan struct{})
+	var once sync.Once
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(buf)))
+
+		if writeAll(w, buf, name) {
+			once.Do(func() { close(done) })
+		}
+	})
+
+	return serve(handler, name, fragment, done)
+}
+
+// writeAll writes buf to w, logging any failure. It reports whether the
+// whole buffer was written.
+func writeAll(w http.ResponseWriter, buf []byte, path string) bool {
+	for len(buf) > 0 {
+		n, err := w.Write(buf)
+		if err != nil {
+			slog.Error("writing response body", "path", path, "err", err)
+			return false
+		}
+		buf = buf[n:]
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: "xdg-open", want: []string{"xdg-open"}},
+		{s: `open -a "Google Chrome"`, want: []string{"open", "-a", "Google Chrome"}},
+		{s: " a \t b  ", want: []string{"a", "b"}},
+		{s: `a\ b c`, want: []string{"a b", "c"}},
+		{s: `'it''s'`, want: []string{"its"}},
+		{s: `'a\b "c"'`, want: []string{`a\b "c"`}},
+		{s: `"a \"b\" c"`, want: []string{`a "b" c`}},
+		{s: `x --opt="{url}"`, want: []string{"x", "--opt={url}"}},
+		{s: `x ""`, want: []string{"x", ""}},
+		{s: "zathura ", want: []string{"zathura"}},
+		{s: `"abc`, wantErr: true},
+		{s: `x 'abc`, wantErr: true},
+		{s: "", wantErr: true},
+		{s: "  ", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := splitCommand(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitCommand(%q) = %q, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+	}
+}
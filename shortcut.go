@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// isShortcut reports whether a file is a link to a document elsewhere: a
+// macOS .webloc or a Windows .url.
+func isShortcut(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".webloc" || ext == ".url"
+}
+
+// shortcutCandidate returns the remote document a shortcut file points
+// at, titled with the shortcut's name.
+func shortcutCandidate(path string) (candidate, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return candidate{}, err
+	}
+
+	var target string
+	if strings.EqualFold(filepath.Ext(path), ".url") {
+		target = urlShortcutTarget(buf)
+	} else {
+		target = weblocTarget(buf)
+	}
+
+	if target == "" {
+		return candidate{}, fmt.Errorf("%s: no url in shortcut", path)
+	}
+	if schemeOf(target) == "" {
+		return candidate{}, fmt.Errorf("%s: can't fetch %s", path, target)
+	}
+	// Like the links in a listing, only ones to documents count; most
+	// shortcuts are bookmarks of web pages.
+	if u, err := url.Parse(target); err != nil || !looksLikeDocument(u.Path) {
+		return candidate{}, fmt.Errorf("%s: %s isn't a document", path, target)
+	}
+
+	name := filepath.Base(path)
+	return candidate{Path: target, Title: strings.TrimSuffix(name, filepath.Ext(name))}, nil
+}
+
+// urlShortcutTarget returns the URL= line of a Windows Internet shortcut,
+// which is an INI file.
+func urlShortcutTarget(buf []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if ok && strings.EqualFold(key, "URL") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// bplistURL finds a url in a binary property list. Picking the format
+// apart isn't worth it for one string, which is stored as plain ASCII.
+var bplistURL = regexp.MustCompile(`[a-z][a-z0-9+.-]*://[\x21-\x7e]+`)
+
+// weblocTarget returns the URL of a .webloc, which is a property list in
+// either XML or binary form.
+func weblocTarget(buf []byte) string {
+	if bytes.HasPrefix(buf, []byte("bplist")) {
+		return string(bplistURL.Find(buf))
+	}
+
+	var plist struct {
+		Dict struct {
+			Items []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"dict"`
+	}
+	if err := xml.Unmarshal(buf, &plist); err != nil {
+		return ""
+	}
+
+	items := plist.Dict.Items
+	for i := 0; i+1 < len(items); i++ {
+		if items[i].XMLName.Local == "key" && items[i].Value == "URL" {
+			return strings.TrimSpace(items[i+1].Value)
+		}
+	}
+	return ""
+}
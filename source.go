@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// A source is a place documents come from: a directory tree, a web server,
+// a file share. Command line arguments with a url scheme name a source by
+// that scheme; anything else is a local path.
+type source interface {
+	// list returns the documents the source holds.
+	list() ([]candidate, error)
+
+	// fetch copies the contents of one of the source's documents to w.
+	fetch(c candidate, w io.Writer) error
+}
+
+// sourceSchemes maps url schemes to constructors for their sources, which
+// take the whole argument. The walker carries the scanning options
+// (excludes, depth, and so on) for sources that can honor them; it's nil
+// when a source is only being created to fetch a document.
+var sourceSchemes = map[string]func(arg string, w *walker) (source, error){
+	"http":      newHTTPSource,
+	"https":     newHTTPSource,
+	"webdav":    newWebDAVSource,
+	"webdavs":   newWebDAVSource,
+	"s3":        newS3Source,
+	"dropbox":   newDropboxSource,
+	"gdrive":    newGDriveSource,
+	"zotero":    newZoteroSource,
+	"calibre":   newCalibreSource,
+	"paperless": newPaperlessSource,
+	"feed":      newFeedSource,
+	"arxiv":     newArxivSource,
+}
+
+// schemeOf returns the registered source scheme s starts with, or "" if s
+// is a local path.
+func schemeOf(s string) string {
+	scheme, _, ok := strings.Cut(s, ":")
+	if !ok {
+		return ""
+	}
+	if _, ok := sourceSchemes[scheme]; !ok {
+		return ""
+	}
+	return scheme
+}
+
+// newSource returns the source named by a command line argument.
+func newSource(arg string, w *walker) (source, error) {
+	scheme := schemeOf(arg)
+	if scheme == "" {
+		return dirSource{root: arg, w: w}, nil
+	}
+
+	return sourceSchemes[scheme](arg, w)
+}
+
+// dirSource is a directory tree (or a single file) on the local
+// filesystem.
+type dirSource struct {
+	root string
+	w    *walker
+}
+
+func (s dirSource) list() ([]candidate, error) {
+	return s.w.walk(s.root), nil
+}
+
+func (s dirSource) fetch(c candidate, w io.Writer) error {
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// openLibraryDB opens another application's SQLite database read-only.
+// Apps like Zotero keep theirs locked while they're running; immutable
+// tells SQLite not to bother with locks.
+func openLibraryDB(path string) (*sql.DB, error) {
+	u := url.URL{Scheme: "file", OmitHost: true, Path: path, RawQuery: "mode=ro&immutable=1"}
+	return sql.Open("sqlite", u.String())
+}
+
+// fetchCached copies a remote document into the cache, returning its local
+// path. Each document gets its own directory, so the file keeps its
+// original name for the viewer, and in it one for each version of it, by
+// the size and modification time its source lists, or for a url listed
+// without them, what the server says about it. An earlier download of the
+// same version is reused; the others are removed.
+func fetchCached(c candidate) (string, error) {
+	sum := sha256.Sum256([]byte(c.Path))
+	docDir := filepath.Join(cacheDir(), "downloads", hex.EncodeToString(sum[:8]))
+
+	version := fmt.Sprintf("%d %d", c.Size, c.ModTime.UnixNano())
+	if scheme := schemeOf(c.Path); c.Size == 0 && c.ModTime.IsZero() && (scheme == "http" || scheme == "https") {
+		v, err := httpVersion(c.Path)
+		if err != nil {
+			// Offline, say; any download will do.
+			slog.Info("checking for a newer version", "path", c, "err", err)
+			if matches, _ := filepath.Glob(filepath.Join(docDir, "*", c.name())); len(matches) > 0 {
+				return matches[0], nil
+			}
+		}
+		version = v
+	}
+	vsum := sha256.Sum256([]byte(version))
+	dir := filepath.Join(docDir, hex.EncodeToString(vsum[:8]))
+	dst := filepath.Join(dir, c.name())
+
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	// The document changed since it was last downloaded, if it was.
+	if entries, err := os.ReadDir(docDir); err == nil {
+		for _, e := range entries {
+			if e.Name() != filepath.Base(dir) {
+				os.RemoveAll(filepath.Join(docDir, e.Name()))
+			}
+		}
+	}
+
+	scheme := schemeOf(c.Path)
+	if scheme == "" {
+		return "", fmt.Errorf("%s: not a remote document", c)
+	}
+	src, err := sourceSchemes[scheme](c.Path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(src.fetch(c, pw))
+	}()
+	defer pr.Close()
+
+	return dst, writeFileAtomic(dst, pr)
+}
+
+// writeFileAtomic writes r to path by way of a temporary file, so an
+// interrupted write never leaves a partial file behind.
+func writeFileAtomic(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
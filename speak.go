@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// speak reads pages first to last of doc, at path, aloud, after saying
+// what they are, and waits until it's done.
+func speak(t texter, doc candidate, path string, first, last int) error {
+	text, err := pagesText(t, path, first, last)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return errors.New("the page has no text")
+	}
+
+	args, err := speechCommand()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("%s, page %d.\n\n%s\n", docTitle(doc), first, text))
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
+
+// speechCommand returns the command that reads its stdin aloud: the
+// config's speak_command, or the platform's text to speech.
+func speechCommand() ([]string, error) {
+	if cfg.SpeakCommand != "" {
+		args, err := splitCommand(cfg.SpeakCommand)
+		if err != nil {
+			return nil, fmt.Errorf("speak_command: %w", err)
+		}
+		return args, nil
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"say"}, nil
+	case "windows":
+		return []string{"powershell", "-NoProfile", "-Command",
+			"Add-Type -AssemblyName System.Speech; (New-Object System.Speech.Synthesis.SpeechSynthesizer).Speak([Console]::In.ReadToEnd())"}, nil
+	}
+	for _, name := range []string{"espeak-ng", "espeak"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return []string{name, "--stdin"}, nil
+		}
+	}
+	return nil, errors.New("no text to speech found: install espeak-ng, or set speak_command in the config to a command that reads its input aloud")
+}
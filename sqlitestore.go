@@ -0,0 +1,703 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// sqliteStore keeps the state in a SQLite database, with a table for each
+// part of it. The first time, it starts from the state in legacy, if
+// there is one.
+type sqliteStore struct {
+	path   string
+	legacy store
+}
+
+// migrations bring the tables up from each schema version to the next;
+// the version is kept in the database's user_version.
+var migrations = []string{
+	sqliteSchema,
+	`ALTER TABLE picks ADD COLUMN how TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE done (key TEXT PRIMARY KEY, time INTEGER NOT NULL)`,
+	`ALTER TABLE picks ADD COLUMN read INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE picks ADD COLUMN viewed INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE notes (
+		seq INTEGER PRIMARY KEY,
+		key TEXT NOT NULL,
+		doc TEXT NOT NULL,
+		page INTEGER NOT NULL,
+		time INTEGER NOT NULL,
+		text TEXT NOT NULL
+	)`,
+	`CREATE TABLE stamps (
+		kind TEXT NOT NULL,
+		key TEXT NOT NULL,
+		time INTEGER NOT NULL,
+		PRIMARY KEY (kind, key)
+	)`,
+	`CREATE TABLE ratings (key TEXT PRIMARY KEY, rating INTEGER NOT NULL)`,
+	`CREATE TABLE failures (
+		key TEXT PRIMARY KEY,
+		size INTEGER NOT NULL,
+		mtime INTEGER NOT NULL,
+		err TEXT NOT NULL
+	)`,
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS picks (
+	seq INTEGER PRIMARY KEY,
+	key TEXT NOT NULL,
+	doc TEXT NOT NULL,
+	page INTEGER NOT NULL,
+	time INTEGER NOT NULL,
+	profile TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS picks_key ON picks (key);
+CREATE TABLE IF NOT EXISTS seen (
+	key TEXT NOT NULL,
+	page INTEGER NOT NULL,
+	PRIMARY KEY (key, page)
+);
+CREATE TABLE IF NOT EXISTS tags (
+	seq INTEGER PRIMARY KEY,
+	key TEXT NOT NULL,
+	tag TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS banned (
+	key TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS snoozed (
+	key TEXT PRIMARY KEY,
+	until INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS dismissals (
+	key TEXT PRIMARY KEY,
+	count INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS cards (
+	key TEXT PRIMARY KEY,
+	ease REAL NOT NULL,
+	interval REAL NOT NULL,
+	repetitions INTEGER NOT NULL,
+	due INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS page_cards (
+	seq INTEGER PRIMARY KEY,
+	key TEXT NOT NULL,
+	doc TEXT NOT NULL,
+	page INTEGER NOT NULL,
+	ease REAL NOT NULL,
+	interval REAL NOT NULL,
+	repetitions INTEGER NOT NULL,
+	due INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS queue (
+	pos INTEGER PRIMARY KEY,
+	key TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+	name TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// The meta rows hold the parts of the state there's only one of, as
+// JSON.
+const (
+	metaLast  = "last"
+	metaPin   = "pin"
+	metaDealt = "dealt"
+	metaSaved = "saved"
+)
+
+func (s sqliteStore) open() (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return nil, err
+	}
+
+	u := url.URL{Scheme: "file", OmitHost: true, Path: s.path, RawQuery: "_pragma=busy_timeout(5000)"}
+	db, err := sql.Open("sqlite", u.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return db, nil
+}
+
+// migrate brings db's tables up to the latest version.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this randpage's %d", version, len(migrations))
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating to schema version %d: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s sqliteStore) load() (*state, error) {
+	if _, err := os.Stat(s.path); errors.Is(err, fs.ErrNotExist) && s.legacy != nil {
+		// Saving writes it to the database.
+		return s.legacy.load()
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	st := &state{}
+	err = queryRows(db, `SELECT name, value FROM meta`, func(rows *sql.Rows) error {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		switch name {
+		case metaLast:
+			return json.Unmarshal([]byte(value), &st.Last)
+		case metaPin:
+			return json.Unmarshal([]byte(value), &st.Pin)
+		case metaDealt:
+			return json.Unmarshal([]byte(value), &st.Dealt)
+		case metaSaved:
+			return json.Unmarshal([]byte(value), &st.Saved)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT doc, page, time, profile, how, read, viewed FROM picks ORDER BY time, seq`, func(rows *sql.Rows) error {
+		var p pick
+		var doc string
+		var t int64
+		if err := rows.Scan(&doc, &p.Page, &t, &p.Profile, &p.How, &p.Read, &p.Viewed); err != nil {
+			return err
+		}
+		p.Time = time.Unix(0, t)
+		if err := json.Unmarshal([]byte(doc), &p.Doc); err != nil {
+			return err
+		}
+		st.History = append(st.History, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, page FROM seen ORDER BY rowid`, func(rows *sql.Rows) error {
+		var key string
+		var page int
+		if err := rows.Scan(&key, &page); err != nil {
+			return err
+		}
+		if st.Seen == nil {
+			st.Seen = make(map[string][]int)
+		}
+		st.Seen[key] = append(st.Seen[key], page)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, tag FROM tags ORDER BY seq`, func(rows *sql.Rows) error {
+		var key, tag string
+		if err := rows.Scan(&key, &tag); err != nil {
+			return err
+		}
+		if st.Tags == nil {
+			st.Tags = make(map[string][]string)
+		}
+		st.Tags[key] = append(st.Tags[key], tag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key FROM banned`, func(rows *sql.Rows) error {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		if st.Banned == nil {
+			st.Banned = make(map[string]bool)
+		}
+		st.Banned[key] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, time FROM done`, func(rows *sql.Rows) error {
+		var key string
+		var t int64
+		if err := rows.Scan(&key, &t); err != nil {
+			return err
+		}
+		if st.Done == nil {
+			st.Done = make(map[string]time.Time)
+		}
+		st.Done[key] = time.Unix(0, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, until FROM snoozed`, func(rows *sql.Rows) error {
+		var key string
+		var until int64
+		if err := rows.Scan(&key, &until); err != nil {
+			return err
+		}
+		if st.Snoozed == nil {
+			st.Snoozed = make(map[string]time.Time)
+		}
+		st.Snoozed[key] = time.Unix(0, until)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, count FROM dismissals`, func(rows *sql.Rows) error {
+		var key string
+		var n int
+		if err := rows.Scan(&key, &n); err != nil {
+			return err
+		}
+		if st.Dismissals == nil {
+			st.Dismissals = make(map[string]int)
+		}
+		st.Dismissals[key] = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, rating FROM ratings`, func(rows *sql.Rows) error {
+		var key string
+		var rating int
+		if err := rows.Scan(&key, &rating); err != nil {
+			return err
+		}
+		if st.Ratings == nil {
+			st.Ratings = make(map[string]int)
+		}
+		st.Ratings[key] = rating
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key, ease, interval, repetitions, due FROM cards`, func(rows *sql.Rows) error {
+		var key string
+		var c card
+		var due int64
+		if err := rows.Scan(&key, &c.Ease, &c.Interval, &c.Repetitions, &due); err != nil {
+			return err
+		}
+		c.Due = time.Unix(0, due)
+		if st.Cards == nil {
+			st.Cards = make(map[string]*card)
+		}
+		st.Cards[key] = &c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT doc, page, ease, interval, repetitions, due FROM page_cards ORDER BY seq`, func(rows *sql.Rows) error {
+		var c pageCard
+		var doc string
+		var due int64
+		if err := rows.Scan(&doc, &c.Page, &c.Ease, &c.Interval, &c.Repetitions, &due); err != nil {
+			return err
+		}
+		c.Due = time.Unix(0, due)
+		if err := json.Unmarshal([]byte(doc), &c.Doc); err != nil {
+			return err
+		}
+		st.PageCards = append(st.PageCards, &c)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT doc, page, time, text FROM notes ORDER BY time, seq`, func(rows *sql.Rows) error {
+		var n note
+		var doc string
+		var t int64
+		if err := rows.Scan(&doc, &n.Page, &t, &n.Text); err != nil {
+			return err
+		}
+		n.Time = time.Unix(0, t)
+		if err := json.Unmarshal([]byte(doc), &n.Doc); err != nil {
+			return err
+		}
+		st.Notes = append(st.Notes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT kind, key, time FROM stamps`, func(rows *sql.Rows) error {
+		var kind, key string
+		var t int64
+		if err := rows.Scan(&kind, &key, &t); err != nil {
+			return err
+		}
+		if st.Stamps == nil {
+			st.Stamps = make(map[string]map[string]time.Time)
+		}
+		if st.Stamps[kind] == nil {
+			st.Stamps[kind] = make(map[string]time.Time)
+		}
+		st.Stamps[kind][key] = time.Unix(0, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = queryRows(db, `SELECT key FROM queue ORDER BY pos`, func(rows *sql.Rows) error {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		st.Queue = append(st.Queue, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// stateDatabase returns the database the state is kept in, if it's kept
+// in one.
+func stateDatabase() (sqliteStore, bool) {
+	s := stateStore
+	if ss, ok := s.(syncStore); ok {
+		s = ss.store
+	}
+	db, ok := s.(sqliteStore)
+	return db, ok
+}
+
+// exists reports whether the database has been made yet. Until it has,
+// the state is still in the legacy store.
+func (s sqliteStore) exists() bool {
+	_, err := os.Stat(s.path)
+	return err == nil
+}
+
+// loadFailures returns the documents that couldn't be read, and why.
+func (s sqliteStore) loadFailures() (map[string]pageCount, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	ret := make(map[string]pageCount)
+	err = queryRows(db, `SELECT key, size, mtime, err FROM failures`, func(rows *sql.Rows) error {
+		var key string
+		var e pageCount
+		var mtime int64
+		if err := rows.Scan(&key, &e.Size, &mtime, &e.Err); err != nil {
+			return err
+		}
+		e.ModTime = time.Unix(0, mtime)
+		ret[key] = e
+		return nil
+	})
+	return ret, err
+}
+
+// saveFailures replaces the documents that couldn't be read with the
+// failed entries.
+func (s sqliteStore) saveFailures(entries map[string]pageCount) error {
+	t := table{name: "failures", ids: []string{"key"}, cols: []string{"size", "mtime", "err"}}
+	for key, e := range entries {
+		if e.Err != "" {
+			t.add(key, e.Size, e.ModTime.UnixNano(), e.Err)
+		}
+	}
+	return s.write(t)
+}
+
+// queryRows calls f with each row query returns.
+func queryRows(db *sql.DB, query string, f func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := f(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// save writes st to the database in one transaction, touching only the
+// rows that differ from what's there.
+func (s sqliteStore) save(st *state) error {
+	tables, err := stateTables(st)
+	if err != nil {
+		return err
+	}
+	return s.write(tables...)
+}
+
+// write makes each of tables in the database hold just its rows, all at
+// once.
+func (s sqliteStore) write(tables ...table) error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, t := range tables {
+		if err := t.sync(tx); err != nil {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// stateTables returns the rows of each table for st.
+func stateTables(st *state) ([]table, error) {
+	var err error
+	asJSON := func(v any) string {
+		buf, jerr := json.Marshal(v)
+		if jerr != nil && err == nil {
+			err = jerr
+		}
+		return string(buf)
+	}
+
+	meta := table{name: "meta", ids: []string{"name"}, cols: []string{"value"}}
+	meta.add(metaLast, asJSON(st.Last))
+	meta.add(metaPin, asJSON(st.Pin))
+	meta.add(metaDealt, asJSON(st.Dealt))
+	meta.add(metaSaved, asJSON(st.Saved))
+	picks := table{name: "picks", ids: []string{"key", "time"}, cols: []string{"doc", "page", "profile", "how", "read", "viewed"}}
+	for _, p := range st.History {
+		picks.add(p.Doc.key(), p.Time.UnixNano(), asJSON(p.Doc), p.Page, p.Profile, p.How, p.Read, p.Viewed)
+	}
+	seen := table{name: "seen", ids: []string{"key", "page"}}
+	for key, pages := range st.Seen {
+		for _, page := range pages {
+			seen.add(key, page)
+		}
+	}
+	tags := table{name: "tags", ids: []string{"key", "tag"}}
+	for key, ts := range st.Tags {
+		for _, tag := range ts {
+			tags.add(key, tag)
+		}
+	}
+	banned := table{name: "banned", ids: []string{"key"}}
+	for key, b := range st.Banned {
+		if b {
+			banned.add(key)
+		}
+	}
+	done := table{name: "done", ids: []string{"key"}, cols: []string{"time"}}
+	for key, t := range st.Done {
+		done.add(key, t.UnixNano())
+	}
+	snoozed := table{name: "snoozed", ids: []string{"key"}, cols: []string{"until"}}
+	for key, until := range st.Snoozed {
+		snoozed.add(key, until.UnixNano())
+	}
+	dismissals := table{name: "dismissals", ids: []string{"key"}, cols: []string{"count"}}
+	for key, n := range st.Dismissals {
+		dismissals.add(key, n)
+	}
+	ratings := table{name: "ratings", ids: []string{"key"}, cols: []string{"rating"}}
+	for key, r := range st.Ratings {
+		ratings.add(key, r)
+	}
+	cards := table{name: "cards", ids: []string{"key"}, cols: []string{"ease", "interval", "repetitions", "due"}}
+	for key, c := range st.Cards {
+		cards.add(key, c.Ease, c.Interval, c.Repetitions, c.Due.UnixNano())
+	}
+	pageCards := table{name: "page_cards", ids: []string{"key", "page"}, cols: []string{"doc", "ease", "interval", "repetitions", "due"}}
+	for _, c := range st.PageCards {
+		pageCards.add(c.Doc.key(), c.Page, asJSON(c.Doc), c.Ease, c.Interval, c.Repetitions, c.Due.UnixNano())
+	}
+	notes := table{name: "notes", ids: []string{"key", "time", "text"}, cols: []string{"doc", "page"}}
+	for _, n := range st.Notes {
+		notes.add(n.Doc.key(), n.Time.UnixNano(), n.Text, asJSON(n.Doc), n.Page)
+	}
+	stamps := table{name: "stamps", ids: []string{"kind", "key"}, cols: []string{"time"}}
+	for kind, ss := range st.Stamps {
+		for key, t := range ss {
+			stamps.add(kind, key, t.UnixNano())
+		}
+	}
+	queue := table{name: "queue", ids: []string{"pos"}, cols: []string{"key"}}
+	for i, key := range st.Queue {
+		queue.add(i, key)
+	}
+
+	return []table{meta, picks, seen, tags, banned, done, snoozed, dismissals, ratings, cards, pageCards, notes, stamps, queue}, err
+}
+
+// A table is what one of the database's tables should hold: rows told
+// apart by the values of the ids columns, with the values of the cols
+// columns after them.
+type table struct {
+	name string
+	ids  []string
+	cols []string
+	rows [][]any
+}
+
+// add adds a row, in column order, with its values in the types the
+// database gives back.
+func (t *table) add(values ...any) {
+	row := make([]any, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case int:
+			row[i] = int64(v)
+		case time.Duration:
+			row[i] = int64(v)
+		case bool:
+			row[i] = int64(0)
+			if v {
+				row[i] = int64(1)
+			}
+		default:
+			row[i] = v
+		}
+	}
+	t.rows = append(t.rows, row)
+}
+
+// sync makes the table hold just t's rows, deleting, updating, and
+// inserting only where it differs. Rows are inserted in t's order, which
+// is the order of their rowids.
+func (t table) sync(tx *sql.Tx) error {
+	columns := append(slices.Clone(t.ids), t.cols...)
+	rows, err := tx.Query(`SELECT ` + strings.Join(columns, ", ") + ` FROM ` + t.name)
+	if err != nil {
+		return err
+	}
+	have := make(map[string][]any)
+	for rows.Next() {
+		row := make([]any, len(columns))
+		ptrs := make([]any, len(row))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			rows.Close()
+			return err
+		}
+		have[rowKey(row[:len(t.ids)])] = row
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	where := strings.Join(t.ids, " = ? AND ") + " = ?"
+	want := make(map[string]bool)
+	for _, row := range t.rows {
+		id := rowKey(row[:len(t.ids)])
+		if want[id] {
+			continue
+		}
+		want[id] = true
+
+		old, ok := have[id]
+		switch {
+		case !ok:
+			_, err = tx.Exec(`INSERT INTO `+t.name+` (`+strings.Join(columns, ", ")+`) VALUES (?`+strings.Repeat(", ?", len(columns)-1)+`)`, row...)
+		case len(t.cols) > 0 && rowKey(old[len(t.ids):]) != rowKey(row[len(t.ids):]):
+			set := strings.Join(t.cols, " = ?, ") + " = ?"
+			_, err = tx.Exec(`UPDATE `+t.name+` SET `+set+` WHERE `+where, append(slices.Clone(row[len(t.ids):]), row[:len(t.ids)]...)...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, row := range have {
+		if !want[id] {
+			if _, err := tx.Exec(`DELETE FROM `+t.name+` WHERE `+where, row[:len(t.ids)]...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rowKey returns a string telling values apart from any others.
+func rowKey(values []any) string {
+	var b strings.Builder
+	for _, v := range values {
+		if buf, ok := v.([]byte); ok {
+			v = string(buf)
+		}
+		fmt.Fprintf(&b, "%T:%v\x00", v, v)
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestTableSync syncs a table through a series of states, checking that
+// rows are changed in place: a row that's kept keeps its rowid.
+func TestTableSync(t *testing.T) {
+	db, err := sqliteStore{path: filepath.Join(t.TempDir(), "randpage.db")}.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE things (key TEXT, n INTEGER, text TEXT, flag INTEGER, PRIMARY KEY (key, n))`); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		rows [][]any
+		want []string // rowid key n text flag, by rowid
+	}{
+		{
+			name: "insert",
+			rows: [][]any{{"a", 1, "x", true}, {"b", 2, "y", false}},
+			want: []string{"1 a 1 x 1", "2 b 2 y 0"},
+		},
+		{
+			name: "unchanged",
+			rows: [][]any{{"a", 1, "x", true}, {"b", 2, "y", false}},
+			want: []string{"1 a 1 x 1", "2 b 2 y 0"},
+		},
+		{
+			name: "update and insert",
+			rows: [][]any{{"a", 1, "x", true}, {"b", 2, "z", false}, {"c", 3 * time.Nanosecond, "w", true}},
+			want: []string{"1 a 1 x 1", "2 b 2 z 0", "3 c 3 w 1"},
+		},
+		{
+			name: "same ids twice",
+			rows: [][]any{{"a", 1, "x", true}, {"b", 2, "z", false}, {"c", 3, "w", true}, {"c", 3, "again", false}},
+			want: []string{"1 a 1 x 1", "2 b 2 z 0", "3 c 3 w 1"},
+		},
+		{
+			name: "delete",
+			rows: [][]any{{"b", 2, "z", false}, {"c", 3, "w", true}},
+			want: []string{"2 b 2 z 0", "3 c 3 w 1"},
+		},
+		{
+			name: "same key, another id",
+			rows: [][]any{{"b", 2, "z", false}, {"b", 4, "z", false}, {"c", 3, "w", true}},
+			want: []string{"2 b 2 z 0", "3 c 3 w 1", "4 b 4 z 0"},
+		},
+		{
+			name: "empty",
+			want: nil,
+		},
+	}
+
+	for _, step := range steps {
+		tbl := table{name: "things", ids: []string{"key", "n"}, cols: []string{"text", "flag"}}
+		for _, row := range step.rows {
+			tbl.add(row...)
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.sync(tx); err != nil {
+			tx.Rollback()
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := db.Query(`SELECT rowid, key, n, text, flag FROM things ORDER BY rowid`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var rowid, n, flag int64
+			var key, text string
+			if err := rows.Scan(&rowid, &key, &n, &text, &flag); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%d %s %d %s %d", rowid, key, n, text, flag))
+		}
+		rows.Close()
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: rows = %q, want %q", step.name, got, step.want)
+		}
+	}
+}
+
+func TestRowKey(t *testing.T) {
+	tests := []struct {
+		a, b []any
+		same bool
+	}{
+		{[]any{"a", int64(1)}, []any{"a", int64(1)}, true},
+		{[]any{"a", []byte("x")}, []any{"a", "x"}, true},
+		{[]any{"a", int64(1)}, []any{"a", "1"}, false},
+		{[]any{"ab", "c"}, []any{"a", "bc"}, false},
+		{[]any{"a", nil}, []any{"a", ""}, false},
+	}
+	for _, tt := range tests {
+		if same := rowKey(tt.a) == rowKey(tt.b); same != tt.same {
+			t.Errorf("rowKey(%q) == rowKey(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A state is what randpage remembers between runs. It's small enough to
+// load and save whole, from a store.
+type state struct {
+	// Last is the most recent pick.
+	Last *pick `json:"last,omitempty"`
+
+	// Pin is the document every pick comes from, if one is pinned.
+	Pin *pin `json:"pin,omitempty"`
+
+	// History is the picks historyRetention keeps, oldest first.
+	History []pick `json:"history,omitempty"`
+
+	// Seen are the pages shown from each document since it was last
+	// finished, by candidate.key().
+	Seen map[string][]int `json:"seen,omitempty"`
+
+	// Tags are the tags given to documents with randpage tag, by
+	// candidate.key().
+	Tags map[string][]string `json:"tags,omitempty"`
+
+	// Banned are the documents never to pick again, by candidate.key().
+	Banned map[string]bool `json:"banned,omitempty"`
+
+	// Done are the documents marked finished with randpage done, and when,
+	// by candidate.key().
+	Done map[string]time.Time `json:"done,omitempty"`
+
+	// Snoozed are the documents not to pick until a time, by
+	// candidate.key().
+	Snoozed map[string]time.Time `json:"snoozed,omitempty"`
+
+	// Dismissals count the times each document was dismissed, less the
+	// times it was kept, by candidate.key().
+	Dismissals map[string]int `json:"dismissals,omitempty"`
+
+	// Ratings are the ratings given to documents with randpage rate, from
+	// 1 to 5, by candidate.key().
+	Ratings map[string]int `json:"ratings,omitempty"`
+
+	// Cards are the documents scheduled for revisiting, by
+	// candidate.key().
+	Cards map[string]*card `json:"cards,omitempty"`
+
+	// Queue is the order documents come up in with --strategy queue, by
+	// candidate.key(), and Dealt is how many of them have come up in this
+	// cycle through it.
+	Queue []string `json:"queue,omitempty"`
+	Dealt int      `json:"dealt,omitempty"`
+
+	// PageCards are the pages scheduled for revisiting, in the order
+	// they were added.
+	PageCards []*pageCard `json:"page_cards,omitempty"`
+
+	// Notes are the notes taken on picks with randpage note, oldest
+	// first.
+	Notes []note `json:"notes,omitempty"`
+
+	// Saved is when the state was last saved, to tell which of two
+	// copies is newer when they're synced.
+	Saved time.Time `json:"saved,omitempty"`
+
+	// Stamps are when each entry of the state was last changed, by kind
+	// and then key, so that synced copies can be merged entry by entry.
+	// An entry that's stamped but missing was taken out.
+	Stamps map[string]map[string]time.Time `json:"stamps,omitempty"`
+}
+
+// The kinds of entries in Stamps. Most are keyed like the fields they go
+// with; page cards are keyed by pageKey, the pin by "", seen by when
+// each document's pages, or with "" every document's, started over, and
+// undone by the id of the pick taken back.
+const (
+	kindTags       = "tags"
+	kindBanned     = "banned"
+	kindDone       = "done"
+	kindSnoozed    = "snoozed"
+	kindDismissals = "dismissals"
+	kindRatings    = "ratings"
+	kindCards      = "cards"
+	kindPageCards  = "page_cards"
+	kindPin        = "pin"
+	kindSeen       = "seen"
+	kindUndone     = "undone"
+)
+
+// A pick is a document that was opened, and where, and the --profile it
+// was picked with.
+type pick struct {
+	Doc     candidate `json:"doc"`
+	Page    int       `json:"page"`
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile,omitempty"`
+	How     string    `json:"how,omitempty"`  // one of the how constants
+	Read    bool      `json:"read,omitempty"` // confirmed with randpage read
+
+	// Viewed is how long the page was looked at, for viewers that wait
+	// to be closed; it's 0 for the others, like browsers.
+	Viewed time.Duration `json:"viewed,omitempty"`
+}
+
+// id tells p apart from every other pick.
+func (p pick) id() string {
+	return fmt.Sprintf("%s@%d", pageKey(p.Doc, p.Page), p.Time.UnixNano())
+}
+
+// How a pick was made.
+const (
+	howRandom   = "random"
+	howDaily    = "daily"
+	howReroll   = "reroll"
+	howContinue = "continue"
+	howReview   = "review"
+	howPin      = "pin"
+	howFile     = "file"
+)
+
+// A pin holds picks to one document, at random pages or, if Sequential,
+// each page after the last.
+type pin struct {
+	Doc        candidate `json:"doc"`
+	Sequential bool      `json:"sequential,omitempty"`
+}
+
+// A card schedules a document for revisiting, SM-2 style: each time it's
+// graded well, the interval until it comes back grows by its ease factor.
+type card struct {
+	Ease        float64   `json:"ease"`
+	Interval    float64   `json:"interval"` // days
+	Repetitions int       `json:"repetitions"`
+	Due         time.Time `json:"due"`
+}
+
+// A note is something written about a page of a document.
+type note struct {
+	Doc  candidate `json:"doc"`
+	Page int       `json:"page"`
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// A pageCard schedules one page of a document for revisiting.
+type pageCard struct {
+	Doc  candidate `json:"doc"`
+	Page int       `json:"page"`
+	card
+}
+
+// historyLength is how long picks are kept in the history, unless the
+// config says otherwise.
+const historyLength = 365 * 24 * time.Hour
+
+// A retention is how much of the history to keep: the picks within keep
+// of the latest, if keep isn't 0, and of those the latest max, if max
+// isn't 0.
+type retention struct {
+	keep time.Duration
+	max  int
+}
+
+// historyRetention is how much history is kept.
+var historyRetention = retention{keep: historyLength}
+
+// prune returns the picks in history, oldest first, that r keeps as of
+// now.
+func (r retention) prune(history []pick, now time.Time) []pick {
+	i := 0
+	if r.keep > 0 {
+		cutoff := now.Add(-r.keep)
+		for i < len(history) && history[i].Time.Before(cutoff) {
+			i++
+		}
+	}
+	if r.max > 0 {
+		i = max(i, len(history)-r.max)
+	}
+	return history[i:]
+}
+
+// newRetention returns the retention for keep, an age or "forever" (or ""
+// for historyLength), and most, for no more than that many picks unless
+// it's 0.
+func newRetention(keep string, most int) (retention, error) {
+	r := retention{keep: historyLength, max: most}
+	switch keep {
+	case "":
+	case "forever":
+		r.keep = 0
+	default:
+		var a age
+		if err := a.Set(keep); err != nil {
+			return retention{}, err
+		}
+		if a == 0 {
+			return retention{}, fmt.Errorf("invalid age %q: keeping nothing would lose the history (want forever for no limit)", keep)
+		}
+		r.keep = time.Duration(a)
+	}
+	if most < 0 {
+		return retention{}, fmt.Errorf("invalid maximum %d", most)
+	}
+	return r, nil
+}
+
+// loadState loads the state from the store, which is empty the first
+// time, and prunes its history.
+func loadState() (*state, error) {
+	st, err := stateStore.load()
+	if err != nil {
+		return nil, err
+	}
+	st.prune(historyRetention, time.Now())
+	return st, nil
+}
+
+// prune drops the picks r doesn't keep as of now from the history,
+// returning how many.
+func (st *state) prune(r retention, now time.Time) int {
+	kept := r.prune(st.History, now)
+	n := len(st.History) - len(kept)
+	st.History = kept
+	return n
+}
+
+func (st *state) save() error {
+	st.Saved = time.Now()
+	return stateStore.save(st)
+}
+
+// touch stamps the entry kind key as changed now.
+func (st *state) touch(kind, key string) {
+	if st.Stamps == nil {
+		st.Stamps = make(map[string]map[string]time.Time)
+	}
+	if st.Stamps[kind] == nil {
+		st.Stamps[kind] = make(map[string]time.Time)
+	}
+	st.Stamps[kind][key] = time.Now()
+}
+
+// stamp returns when the entry kind key was last changed, or the zero
+// time if it hasn't been since stamps were kept.
+func (st *state) stamp(kind, key string) time.Time {
+	return st.Stamps[kind][key]
+}
+
+// pageKey is the key of page of doc.
+func pageKey(doc candidate, page int) string {
+	return fmt.Sprintf("%s#%d", doc.key(), page)
+}
+
+// picked records p as the latest pick.
+func (st *state) picked(p pick) {
+	p.Doc = p.Doc.abs()
+	st.Last = &p
+
+	st.History = historyRetention.prune(append(st.History, p), p.Time)
+
+	if st.Seen == nil {
+		st.Seen = make(map[string][]int)
+	}
+	key := p.Doc.key()
+	if !slices.Contains(st.Seen[key], p.Page) {
+		st.Seen[key] = append(st.Seen[key], p.Page)
+	}
+
+	// Deal it from the queue, keeping the order of the rest.
+	if i := slices.Index(st.Queue[min(st.Dealt, len(st.Queue)):], key); i >= 0 {
+		i += st.Dealt
+		copy(st.Queue[st.Dealt+1:i+1], st.Queue[st.Dealt:i])
+		st.Queue[st.Dealt] = key
+		st.Dealt++
+	}
+}
+
+// undo takes the last pick back out of the history, the pages seen, and
+// the queue, as if it hadn't been made, and returns it. The pick before
+// it becomes the last.
+func (st *state) undo() (pick, bool) {
+	if st.Last == nil {
+		return pick{}, false
+	}
+	p := *st.Last
+	key := p.Doc.key()
+
+	st.History = slices.DeleteFunc(st.History, func(q pick) bool { return q.id() == p.id() })
+	st.Last = nil
+	if n := len(st.History); n > 0 {
+		last := st.History[n-1]
+		st.Last = &last
+	}
+
+	// The page stays seen if it was picked again since the document
+	// started over.
+	since := latest(st.stamp(kindSeen, key), st.stamp(kindSeen, ""))
+	if !slices.ContainsFunc(st.History, func(q pick) bool { return q.Doc.key() == key && q.Page == p.Page && q.Time.After(since) }) {
+		if pages := slices.DeleteFunc(st.Seen[key], func(page int) bool { return page == p.Page }); len(pages) > 0 {
+			st.Seen[key] = pages
+		} else {
+			delete(st.Seen, key)
+		}
+	}
+
+	if st.Dealt > 0 && st.Dealt <= len(st.Queue) && st.Queue[st.Dealt-1] == key {
+		st.Dealt--
+	}
+
+	st.touch(kindUndone, p.id())
+	return p, true
+}
+
+// snoozed reports whether doc is snoozed at now.
+func (st *state) snoozed(doc candidate, now time.Time) bool {
+	return now.Before(st.Snoozed[doc.key()])
+}
+
+// snooze keeps doc from being picked until until, forgetting the snoozes
+// that have run out.
+func (st *state) snooze(doc candidate, until time.Time) {
+	now := time.Now()
+	for key, t := range st.Snoozed {
+		if !now.Before(t) {
+			delete(st.Snoozed, key)
+		}
+	}
+
+	st.touch(kindSnoozed, doc.key())
+	if !now.Before(until) {
+		delete(st.Snoozed, doc.key())
+		return
+	}
+	if st.Snoozed == nil {
+		st.Snoozed = make(map[string]time.Time)
+	}
+	st.Snoozed[doc.key()] = until
+}
+
+// dismissed records that doc was dismissed as not interesting.
+func (st *state) dismissed(doc candidate) {
+	if st.Dismissals == nil {
+		st.Dismissals = make(map[string]int)
+	}
+	st.Dismissals[doc.key()]++
+	st.touch(kindDismissals, doc.key())
+}
+
+// kept records that doc held interest, taking back a dismissal.
+func (st *state) kept(doc candidate) {
+	if n := st.Dismissals[doc.key()]; n > 1 {
+		st.Dismissals[doc.key()] = n - 1
+	} else {
+		delete(st.Dismissals, doc.key())
+	}
+	st.touch(kindDismissals, doc.key())
+}
+
+// rate gives doc rating, from 1 to 5, or with 0 takes its rating away.
+func (st *state) rate(doc candidate, rating int) {
+	if rating == 0 {
+		delete(st.Ratings, doc.key())
+	} else {
+		if st.Ratings == nil {
+			st.Ratings = make(map[string]int)
+		}
+		st.Ratings[doc.key()] = rating
+	}
+	st.touch(kindRatings, doc.key())
+}
+
+// opened returns the keys of the documents that have been picked.
+func (st *state) opened() map[string]bool {
+	ret := make(map[string]bool)
+	for _, p := range st.History {
+		ret[p.Doc.key()] = true
+	}
+	for key := range st.Seen {
+		ret[key] = true
+	}
+	return ret
+}
+
+// unseen returns how many of pages first to last of doc haven't been
+// seen.
+func (st *state) unseen(doc candidate, first, last int) int {
+	seen := 0
+	for _, page := range st.Seen[doc.key()] {
+		if page >= first && page <= last {
+			seen++
+		}
+	}
+	return last - first + 1 - seen
+}
+
+// randomPage picks a page from first to last of doc that hasn't been
+// seen. Once every page has been, they all start over as unseen.
+func (st *state) randomPage(doc candidate, first, last int, rnd *rand.Rand) int {
+	seen := make(map[int]bool)
+	for _, page := range st.Seen[doc.key()] {
+		if page >= first && page <= last {
+			seen[page] = true
+		}
+	}
+	n := last - first + 1
+	if len(seen) >= n {
+		delete(st.Seen, doc.key())
+		st.touch(kindSeen, doc.key())
+		return first + rnd.Intn(n)
+	}
+
+	k := rnd.Intn(n - len(seen))
+	for page := first; ; page++ {
+		if seen[page] {
+			continue
+		}
+		if k == 0 {
+			return page
+		}
+		k--
+	}
+}
+
+// randomOf picks one of pages that hasn't been seen in doc, or any of
+// them if they all have been.
+func (st *state) randomOf(doc candidate, pages []int, rnd *rand.Rand) int {
+	var unseen []int
+	for _, page := range pages {
+		if !slices.Contains(st.Seen[doc.key()], page) {
+			unseen = append(unseen, page)
+		}
+	}
+	if len(unseen) == 0 {
+		unseen = pages
+	}
+	return unseen[rnd.Intn(len(unseen))]
+}
+
+// tagged reports whether doc has one of tags, either from its source or
+// from randpage tag.
+func (st *state) tagged(doc candidate, tags []string) bool {
+	have := append(append([]string{}, doc.Tags...), st.Tags[doc.key()]...)
+	for _, tag := range tags {
+		for _, t := range have {
+			if strings.EqualFold(tag, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tag gives doc tag, reporting whether it didn't have it already.
+func (st *state) tag(doc candidate, tag string) bool {
+	key := doc.key()
+	if slices.ContainsFunc(st.Tags[key], func(t string) bool { return strings.EqualFold(t, tag) }) {
+		return false
+	}
+
+	if st.Tags == nil {
+		st.Tags = make(map[string][]string)
+	}
+	st.Tags[key] = append(st.Tags[key], tag)
+	st.touch(kindTags, key)
+	return true
+}
+
+// untag takes tag off doc, reporting whether it had it.
+func (st *state) untag(doc candidate, tag string) bool {
+	key := doc.key()
+	tags := slices.DeleteFunc(slices.Clone(st.Tags[key]), func(t string) bool { return strings.EqualFold(t, tag) })
+	if len(tags) == len(st.Tags[key]) {
+		return false
+	}
+
+	if len(tags) == 0 {
+		delete(st.Tags, key)
+	} else {
+		st.Tags[key] = tags
+	}
+	st.touch(kindTags, key)
+	return true
+}
+
+// grade reschedules doc after a review of quality q, from 0 (forgot it
+// entirely) to 5 (perfect).
+func (st *state) grade(doc candidate, q int, now time.Time) *card {
+	if st.Cards == nil {
+		st.Cards = make(map[string]*card)
+	}
+	c, ok := st.Cards[doc.key()]
+	if !ok {
+		c = newCard()
+		st.Cards[doc.key()] = c
+	}
+
+	c.grade(q, now)
+	st.touch(kindCards, doc.key())
+	return c
+}
+
+// gradePage reschedules page of doc like grade does a document.
+func (st *state) gradePage(doc candidate, page int, q int, now time.Time) *card {
+	i := st.pageCard(doc, page)
+	if i < 0 {
+		i = len(st.PageCards)
+		st.PageCards = append(st.PageCards, &pageCard{Doc: doc.abs(), Page: page, card: *newCard()})
+	}
+
+	c := &st.PageCards[i].card
+	c.grade(q, now)
+	st.touch(kindPageCards, pageKey(doc, page))
+	return c
+}
+
+// pageCard returns the index of the card for page of doc, or -1 if it
+// isn't scheduled.
+func (st *state) pageCard(doc candidate, page int) int {
+	return slices.IndexFunc(st.PageCards, func(c *pageCard) bool {
+		return c.Page == page && c.Doc.key() == doc.key()
+	})
+}
+
+// duePages returns the pages due at now, the longest overdue first.
+func (st *state) duePages(now time.Time) []*pageCard {
+	var ret []*pageCard
+	for _, c := range st.PageCards {
+		if !now.Before(c.Due) {
+			ret = append(ret, c)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Due.Before(ret[j].Due)
+	})
+	return ret
+}
+
+func newCard() *card {
+	return &card{Ease: 2.5}
+}
+
+// grade reschedules c after a review of quality q, per SM-2.
+func (c *card) grade(q int, now time.Time) {
+	if q < 3 {
+		c.Repetitions = 0
+		c.Interval = 1
+	} else {
+		switch c.Repetitions {
+		case 0:
+			c.Interval = 1
+		case 1:
+			c.Interval = 6
+		default:
+			c.Interval = math.Round(c.Interval * c.Ease)
+		}
+		c.Repetitions++
+	}
+
+	d := float64(5 - q)
+	c.Ease = max(1.3, c.Ease+0.1-d*(0.08+d*0.02))
+	c.Due = now.Add(time.Duration(c.Interval * 24 * float64(time.Hour)))
+}
+
+// schedule reorders docs, which are already shuffled, for the
+// revisiting schedule: documents that are due come first, then
+// unscheduled ones, then ones that aren't due yet, and last the ones
+// opened since recent, least recently opened first.
+func (st *state) schedule(docs []candidate, now, recent time.Time) []candidate {
+	opened := make(map[string]time.Time)
+	if !recent.IsZero() {
+		for _, p := range st.History {
+			if p.Time.After(recent) {
+				opened[p.Doc.key()] = p.Time
+			}
+		}
+	}
+	if len(st.Cards) == 0 && len(opened) == 0 {
+		return docs
+	}
+
+	var due, rest, later, again []candidate
+	for _, doc := range docs {
+		c, ok := st.Cards[doc.key()]
+		switch {
+		case ok && !now.Before(c.Due):
+			due = append(due, doc)
+		case !opened[doc.key()].IsZero():
+			again = append(again, doc)
+		case !ok:
+			rest = append(rest, doc)
+		default:
+			later = append(later, doc)
+		}
+	}
+
+	sort.SliceStable(again, func(i, j int) bool {
+		return opened[again[i].key()].Before(opened[again[j].key()])
+	})
+
+	return append(append(append(due, rest...), later...), again...)
+}
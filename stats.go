@@ -0,0 +1,310 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statsCommand reports on the library and what's been read of it:
+// randpage stats [-n count] [-weeks count]. The library is what the last
+// run found, and the pages are counted as --min-pages would count them.
+func statsCommand(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	n := fs.Int("n", 5, "list the `count` most and least visited documents")
+	weeks := fs.Int("weeks", 12, "show picks for the last `count` weeks")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: randpage stats [-n count] [-weeks count]")
+	}
+
+	st, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	docs, err := loadLastScan()
+	if err != nil {
+		return err
+	}
+
+	pc := loadPageCounts()
+	defer pc.save()
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer out.Flush()
+
+	s := libraryStats(docs, st, pc)
+	s.print(out, *n)
+	printGoals(out, st, docs, pc, time.Now())
+	printWeeks(out, st.History, *weeks, time.Now())
+	return nil
+}
+
+// A docStats is what's been read of one document.
+type docStats struct {
+	doc    candidate
+	pages  int // 0 if they couldn't be counted
+	seen   int
+	visits int
+	done   bool
+	spent  time.Duration
+	rating int // 0 if it isn't rated
+}
+
+func (d docStats) coverage() float64 {
+	if d.pages == 0 {
+		return 0
+	}
+	return float64(min(d.seen, d.pages)) / float64(d.pages)
+}
+
+type stats struct {
+	docs        []docStats
+	pages       int
+	seen        int
+	seenCounted int // of the documents whose pages were counted
+	uncounted   int
+	done        int
+	rated       int
+	ratings     int // the sum of them
+
+	streak, longestStreak int
+	spent                 time.Duration
+}
+
+// libraryStats gathers the stats for docs. Remote documents are only
+// counted if their pages are already known, so stats doesn't download
+// the library.
+func libraryStats(docs []candidate, st *state, pc *pageCounts) stats {
+	visits := make(map[string]int)
+	spent := make(map[string]time.Duration)
+	for i, p := range st.History {
+		visits[p.Doc.key()]++
+		spent[p.Doc.key()] += readingTime(st.History, i)
+	}
+
+	var s stats
+	s.streak, s.longestStreak = streak(st.History, time.Now(), *streakRead)
+	for _, doc := range docs {
+		doc = statLocal(doc)
+		d := docStats{doc: doc, seen: len(st.Seen[doc.key()]), visits: visits[doc.key()], spent: spent[doc.key()], rating: st.Ratings[doc.key()]}
+		if _, ok := st.Done[doc.key()]; ok {
+			d.done = true
+			s.done++
+		}
+		if d.rating > 0 {
+			s.rated++
+			s.ratings += d.rating
+		}
+		if !doc.remote() || pc.known(doc) {
+			if pages, err := pc.count(doc, *fileTimeout); err == nil {
+				d.pages = pages
+			}
+		}
+		s.seen += d.seen
+		s.spent += d.spent
+		if d.pages == 0 {
+			s.uncounted++
+		} else {
+			s.pages += d.pages
+			s.seenCounted += min(d.seen, d.pages)
+		}
+		s.docs = append(s.docs, d)
+	}
+	return s
+}
+
+func (s stats) print(w io.Writer, n int) {
+	fmt.Fprintf(w, "documents\t%d\n", len(s.docs))
+	fmt.Fprintf(w, "done\t%d%s\n", s.done, percent(s.done, len(s.docs)))
+	pages := fmt.Sprint(s.pages)
+	if s.uncounted > 0 {
+		pages += fmt.Sprintf(" (%d documents not counted)", s.uncounted)
+	}
+	fmt.Fprintf(w, "pages\t%s\n", pages)
+	fmt.Fprintf(w, "pages seen\t%d%s\n", s.seen, percent(s.seenCounted, s.pages))
+	fmt.Fprintf(w, "time reading\t%s\n", roughly(s.spent))
+	if s.rated > 0 {
+		fmt.Fprintf(w, "rated\t%d (average %.1f)\n", s.rated, float64(s.ratings)/float64(s.rated))
+	}
+	fmt.Fprintf(w, "streak\t%s (longest %s)\n", days(s.streak), days(s.longestStreak))
+
+	byVisits := append([]docStats(nil), s.docs...)
+	sort.SliceStable(byVisits, func(i, j int) bool { return byVisits[i].visits > byVisits[j].visits })
+	n = max(0, min(n, len(byVisits)))
+
+	fmt.Fprintf(w, "\nmost visited\n")
+	for _, d := range byVisits[:n] {
+		fmt.Fprintf(w, "  %d\t%s\n", d.visits, d.doc)
+	}
+	fmt.Fprintf(w, "\nleast visited\n")
+	for i := len(byVisits) - 1; i >= len(byVisits)-n; i-- {
+		fmt.Fprintf(w, "  %d\t%s\n", byVisits[i].visits, byVisits[i].doc)
+	}
+
+	bySpent := append([]docStats(nil), s.docs...)
+	sort.SliceStable(bySpent, func(i, j int) bool { return bySpent[i].spent > bySpent[j].spent })
+
+	fmt.Fprintf(w, "\nmost time reading\n")
+	for _, d := range bySpent[:n] {
+		if d.spent == 0 {
+			break
+		}
+		fmt.Fprintf(w, "  %s\t%s\n", roughly(d.spent), d.doc)
+	}
+
+	byRating := append([]docStats(nil), s.docs...)
+	sort.SliceStable(byRating, func(i, j int) bool { return byRating[i].rating > byRating[j].rating })
+
+	if s.rated > 0 {
+		fmt.Fprintf(w, "\nhighest rated\n")
+		for _, d := range byRating[:n] {
+			if d.rating == 0 {
+				break
+			}
+			fmt.Fprintf(w, "  %s\t%s\n", stars(d.rating), d.doc)
+		}
+	}
+
+	// The nearest to done come first, and the ones that couldn't be
+	// counted last.
+	byCoverage := append([]docStats(nil), s.docs...)
+	sort.SliceStable(byCoverage, func(i, j int) bool {
+		a, b := byCoverage[i], byCoverage[j]
+		if (a.pages == 0) != (b.pages == 0) {
+			return b.pages == 0
+		}
+		return a.coverage() > b.coverage()
+	})
+
+	fmt.Fprintf(w, "\ncoverage\n")
+	for _, d := range byCoverage {
+		name := d.doc.String()
+		if d.done {
+			name += " (done)"
+		}
+		if d.pages == 0 {
+			fmt.Fprintf(w, "  %s\t?\t%d/?\t%s\n", strings.Repeat(" ", progressWidth+2), d.seen, name)
+			continue
+		}
+		fmt.Fprintf(w, "  %s\t%.0f%%\t%d/%d\t%s\n", progressBar(d.coverage()), 100*d.coverage(), min(d.seen, d.pages), d.pages, name)
+	}
+}
+
+// Reading times are guesses for viewers that don't wait, which leave
+// picks without a time viewed: they're taken to have been read until the
+// next pick, if it came within readingGap.
+const readingGap = 30 * time.Minute
+
+// readingTime returns about how long the pick history[i] was read for.
+func readingTime(history []pick, i int) time.Duration {
+	p := history[i]
+	if p.Viewed > 0 || i+1 == len(history) {
+		return p.Viewed
+	}
+	if gap := history[i+1].Time.Sub(p.Time); gap < readingGap {
+		return gap
+	}
+	return 0
+}
+
+// roughly returns d to the minute, like ~1h5m.
+func roughly(d time.Duration) string {
+	if d < time.Minute {
+		return "<1m"
+	}
+	return "~" + strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+// progressWidth is how many characters wide progress bars are inside
+// their brackets.
+const progressWidth = 20
+
+// progressBar draws a bar filled to the fraction f.
+func progressBar(f float64) string {
+	n := int(f*progressWidth + 0.5)
+	if f > 0 && n == 0 {
+		// Anything at all shows.
+		n = 1
+	}
+	return "[" + strings.Repeat("#", n) + strings.Repeat(".", progressWidth-n) + "]"
+}
+
+// stars returns a rating as stars, like ★★★☆☆.
+func stars(rating int) string {
+	return strings.Repeat("★", rating) + strings.Repeat("☆", 5-rating)
+}
+
+// days returns n days, in words.
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// percent returns n as a percentage of total, in parentheses, or nothing
+// if there's no total.
+func percent(n, total int) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f%%)", 100*float64(n)/float64(total))
+}
+
+// printGoals prints how the goals are going.
+func printGoals(w io.Writer, st *state, docs []candidate, pc *pageCounts, now time.Time) {
+	if len(goals) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\ngoals\n")
+	for _, g := range goals {
+		fmt.Fprintf(w, "  %s\n", g.progress(st, docs, pc, now))
+	}
+}
+
+// printWeeks prints how many of history's picks fell in each of the last
+// weeks weeks before now, starting on Mondays.
+func printWeeks(w io.Writer, history []pick, weeks int, now time.Time) {
+	if weeks <= 0 {
+		return
+	}
+
+	start := startOfWeek(now).AddDate(0, 0, -7*(weeks-1))
+	counts := make([]int, weeks)
+	for _, p := range history {
+		for i := weeks - 1; i >= 0; i-- {
+			if !p.Time.Before(start.AddDate(0, 0, 7*i)) {
+				counts[i]++
+				break
+			}
+		}
+	}
+
+	// Bars longer than barWidth are scaled down to fit.
+	const barWidth = 50
+	most := barWidth
+	for _, c := range counts {
+		most = max(most, c)
+	}
+
+	fmt.Fprintf(w, "\npicks per week\n")
+	for i, c := range counts {
+		week := start.AddDate(0, 0, 7*i)
+		fmt.Fprintf(w, "  %s\t%d\t%s\n", week.Format("Jan 2"), c, strings.Repeat("#", c*barWidth/most))
+	}
+}
+
+// startOfWeek returns midnight on the Monday of t's week.
+func startOfWeek(t time.Time) time.Time {
+	days := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-days, 0, 0, 0, 0, t.Location())
+}
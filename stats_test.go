@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadingTime(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	history := []pick{
+		{Time: t0, Viewed: 3 * time.Minute}, // waited
+		{Time: t0.Add(5 * time.Minute)},     // a browser, read until the next
+		{Time: t0.Add(15 * time.Minute)},    // a browser, left for hours
+		{Time: t0.Add(5 * time.Hour), Viewed: 2 * time.Second},
+		{Time: t0.Add(5*time.Hour + time.Minute)}, // the last
+	}
+	want := []time.Duration{3 * time.Minute, 10 * time.Minute, 0, 2 * time.Second, 0}
+
+	for i := range history {
+		if got := readingTime(history, i); got != want[i] {
+			t.Errorf("readingTime(history, %d) = %v, want %v", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A store keeps the state between runs.
+type store interface {
+	load() (*state, error)
+	save(st *state) error
+}
+
+// stateStore is where the state is kept.
+var stateStore store = defaultStore()
+
+// defaultStore returns the SQLite store, which takes in the JSON file
+// older versions kept the state in.
+func defaultStore() sqliteStore {
+	return sqliteStore{path: filepath.Join(dataDir(), "randpage.db"), legacy: jsonStore{path: filepath.Join(stateDir(), "state.json")}}
+}
+
+// newStore returns the store c chooses.
+func newStore(c stateConfig) (store, error) {
+	var s store
+	var file string
+	path := expandHome(c.Path)
+	switch c.Backend {
+	case "", "sqlite":
+		db := defaultStore()
+		if path != "" {
+			db.path = path
+		}
+		s, file = db, db.path
+	case "json":
+		// The first time, it starts from the database.
+		js := jsonStore{path: filepath.Join(dataDir(), "state.json"), legacy: defaultStore()}
+		if path != "" {
+			js.path = path
+		}
+		s, file = js, js.path
+	default:
+		return nil, fmt.Errorf("unknown backend %q (want sqlite or json)", c.Backend)
+	}
+
+	// Encrypted, the state is kept in a file next to the backend's, and
+	// starts from the backend's the first time.
+	var key *keySource
+	switch c.Encrypt {
+	case "":
+	case "passphrase":
+		key = &keySource{command: c.PassphraseCommand}
+	case "keychain":
+		// Each machine makes its own key, so no other could read the
+		// shared copy.
+		if c.Sync != "" {
+			return nil, errors.New(`syncing an encrypted state needs encrypt = "passphrase": the keychain's key is different on each machine`)
+		}
+		key = &keySource{keychain: true}
+	default:
+		return nil, fmt.Errorf("unknown encryption %q (want passphrase or keychain)", c.Encrypt)
+	}
+	if key != nil {
+		s = encryptedStore{path: file + ".enc", key: *key, legacy: s}
+	}
+
+	if c.Sync != "" {
+		var shared store = jsonStore{path: expandHome(c.Sync)}
+		if key != nil {
+			shared = encryptedStore{path: expandHome(c.Sync), key: *key}
+		}
+		s = syncStore{store: s, shared: shared}
+	}
+	return s, nil
+}
+
+// jsonVersion is the version of the JSON store's format. Files from
+// before it was versioned have none, which reads as 0.
+const jsonVersion = 1
+
+// jsonStore keeps the state in a JSON file, indented and with its keys in
+// a stable order so it diffs well. If there's no file yet, it starts from
+// the state in legacy, if there is one.
+type jsonStore struct {
+	path   string
+	legacy store
+}
+
+// versionedState is the JSON store's file.
+type versionedState struct {
+	Version int `json:"version,omitempty"`
+	*state
+}
+
+func (s jsonStore) load() (*state, error) {
+	buf, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if s.legacy != nil {
+			return s.legacy.load()
+		}
+		return &state{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := decodeState(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	if _, err := s.mergeConflicts(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// conflicts returns the copies of the file a synced folder made when two
+// machines changed it at once: Dropbox's "state (conflicted copy).json"
+// and Syncthing's "state.sync-conflict-....json".
+func (s jsonStore) conflicts() []string {
+	ext := filepath.Ext(s.path)
+	base := strings.TrimSuffix(s.path, ext)
+	var ret []string
+	for _, pattern := range []string{base + " (*conflicted copy*)" + ext, base + ".sync-conflict-*" + ext} {
+		// The only error is a bad pattern, from brackets in the path.
+		matches, _ := filepath.Glob(pattern)
+		ret = append(ret, matches...)
+	}
+	return ret
+}
+
+// mergeConflicts merges the file's conflicting copies into st, returning
+// their paths.
+func (s jsonStore) mergeConflicts(st *state) ([]string, error) {
+	paths := s.conflicts()
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		other, err := decodeState(buf)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		st.merge(other)
+	}
+	return paths, nil
+}
+
+// decodeState reads a state in the JSON store's format.
+func decodeState(buf []byte) (*state, error) {
+	v := versionedState{state: &state{}}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return nil, err
+	}
+	if v.Version > jsonVersion {
+		return nil, fmt.Errorf("version %d is newer than this randpage's %d", v.Version, jsonVersion)
+	}
+	return v.state, nil
+}
+
+// encodeState writes st in the JSON store's format.
+func encodeState(st *state) ([]byte, error) {
+	return json.MarshalIndent(versionedState{Version: jsonVersion, state: st}, "", "  ")
+}
+
+// save writes st to a temporary file and renames it into place, so the
+// file is never half written. Conflicting copies are merged into st
+// first, and removed once it's written.
+func (s jsonStore) save(st *state) error {
+	conflicts, err := s.mergeConflicts(st)
+	if err != nil {
+		return err
+	}
+
+	buf, err := encodeState(st)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.path, bytes.NewReader(buf)); err != nil {
+		return err
+	}
+
+	for _, path := range conflicts {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
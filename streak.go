@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// streak returns how many days in a row, up to now, have at least one
+// pick in history, and the longest such run. A streak isn't broken until
+// a whole day goes by without a pick, so one that ended yesterday still
+// counts. With needRead, only picks confirmed with randpage read count.
+func streak(history []pick, now time.Time, needRead bool) (current, longest int) {
+	days := make(map[time.Time]bool)
+	for _, p := range history {
+		if needRead && !p.Read {
+			continue
+		}
+		days[midnight(p.Time.In(now.Location()))] = true
+	}
+	if len(days) == 0 {
+		return 0, 0
+	}
+
+	day := midnight(now)
+	if !days[day] {
+		day = day.AddDate(0, 0, -1)
+	}
+	for days[day] {
+		current++
+		day = day.AddDate(0, 0, -1)
+	}
+
+	sorted := make([]time.Time, 0, len(days))
+	for d := range days {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	run := 0
+	for i, d := range sorted {
+		if i > 0 && d.Equal(sorted[i-1].AddDate(0, 0, 1)) {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return current, longest
+}
+
+// midnight returns the start of t's day.
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// streakShown is whether this run has logged the streak yet.
+var streakShown bool
+
+// showStreak logs the streak once a run, after its first pick.
+func showStreak(st *state) {
+	if streakShown {
+		return
+	}
+	streakShown = true
+
+	if current, longest := streak(st.History, time.Now(), *streakRead); current > 0 {
+		slog.Info("reading streak", "days", current, "longest", longest)
+	}
+}
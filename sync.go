@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// syncStore keeps the state in a store and merges it with a copy in a
+// file that other machines share, through a synced folder or a git
+// repository, so they all add to the same history.
+type syncStore struct {
+	store
+	shared store
+}
+
+func (s syncStore) load() (*state, error) {
+	st, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	other, err := s.shared.load()
+	if err != nil {
+		return nil, fmt.Errorf("syncing: %w", err)
+	}
+	st.merge(other)
+	return st, nil
+}
+
+// save saves st to both, after merging in the changes other machines have
+// made to the shared copy since st was loaded.
+func (s syncStore) save(st *state) error {
+	other, err := s.shared.load()
+	if err != nil {
+		return fmt.Errorf("syncing: %w", err)
+	}
+	st.merge(other)
+
+	if err := s.store.save(st); err != nil {
+		return err
+	}
+	return s.shared.save(st)
+}
+
+// replace saves st to both in place of what they hold, without merging,
+// so the other machines take it up too.
+func (s syncStore) replace(st *state) error {
+	if err := s.store.save(st); err != nil {
+		return err
+	}
+	return s.shared.save(st)
+}
+
+// merge merges other into st, the same whichever way round they are.
+// The histories and notes add up. The entries kept by document, like tags
+// and bans, come from whichever copy changed each one last, going by
+// their stamps, and both copies' entries are kept where neither has a
+// stamp. Picks undone in either copy are left out. The pages seen are the
+// ones seen since each document was last started over, in either copy.
+// The queue comes from the copy saved last.
+func (st *state) merge(other *state) {
+	newer, older := st, other
+	if other.Saved.After(st.Saved) {
+		newer, older = other, st
+	}
+
+	merged := *newer
+	merged.Stamps = mergeStamps(newer.Stamps, older.Stamps)
+	merged.History = mergeHistory(newer.History, older.History)
+	merged.Notes = mergeNotes(newer.Notes, older.Notes)
+	if older.Last != nil && (newer.Last == nil || older.Last.Time.After(newer.Last.Time)) {
+		merged.Last = older.Last
+	}
+
+	var undone []pick
+	merged.History = slices.DeleteFunc(merged.History, func(p pick) bool {
+		if merged.stamp(kindUndone, p.id()).IsZero() {
+			return false
+		}
+		undone = append(undone, p)
+		return true
+	})
+	if merged.Last != nil && !merged.stamp(kindUndone, merged.Last.id()).IsZero() {
+		merged.Last = nil
+		if n := len(merged.History); n > 0 {
+			last := merged.History[n-1]
+			merged.Last = &last
+		}
+	}
+
+	m := merger{newer: newer, older: older}
+	merged.Tags = mergeByKey(m, kindTags, newer.Tags, older.Tags)
+	merged.Banned = mergeByKey(m, kindBanned, newer.Banned, older.Banned)
+	merged.Done = mergeByKey(m, kindDone, newer.Done, older.Done)
+	merged.Snoozed = mergeByKey(m, kindSnoozed, newer.Snoozed, older.Snoozed)
+	merged.Dismissals = mergeByKey(m, kindDismissals, newer.Dismissals, older.Dismissals)
+	merged.Ratings = mergeByKey(m, kindRatings, newer.Ratings, older.Ratings)
+	merged.Cards = mergeByKey(m, kindCards, newer.Cards, older.Cards)
+	merged.PageCards = mergePageCards(m, newer.PageCards, older.PageCards)
+	if m.later(kindPin, "") == older {
+		merged.Pin = older.Pin
+	}
+	merged.Seen = mergeSeen(m, merged.History, undone)
+
+	*st = merged
+}
+
+// A merger finds which of two copies of the state changed an entry last.
+type merger struct {
+	newer, older *state
+}
+
+// later returns the copy whose change to the entry kind key came last,
+// or nil if neither is later.
+func (m merger) later(kind, key string) *state {
+	a, b := m.newer.stamp(kind, key), m.older.stamp(kind, key)
+	switch {
+	case a.After(b):
+		return m.newer
+	case b.After(a):
+		return m.older
+	}
+	return nil
+}
+
+// mergeByKey merges the entries of kind from the newer copy, a, and the
+// older one, b.
+func mergeByKey[V any](m merger, kind string, a, b map[string]V) map[string]V {
+	keys := make(map[string]bool)
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+
+	var ret map[string]V
+	for key := range keys {
+		va, inA := a[key]
+		vb, inB := b[key]
+		v, ok := va, inA
+		switch m.later(kind, key) {
+		case m.older:
+			v, ok = vb, inB
+		case nil:
+			if !inA {
+				v, ok = vb, inB
+			}
+		}
+		if !ok {
+			continue
+		}
+		if ret == nil {
+			ret = make(map[string]V)
+		}
+		ret[key] = v
+	}
+	return ret
+}
+
+// mergePageCards merges the page cards like mergeByKey, keeping them in
+// the order they were added as far as it can.
+func mergePageCards(m merger, a, b []*pageCard) []*pageCard {
+	byKey := func(cards []*pageCard) map[string]*pageCard {
+		ret := make(map[string]*pageCard)
+		for _, c := range cards {
+			ret[pageKey(c.Doc, c.Page)] = c
+		}
+		return ret
+	}
+	merged := mergeByKey(m, kindPageCards, byKey(a), byKey(b))
+
+	var ret []*pageCard
+	for _, c := range append(slices.Clone(a), b...) {
+		key := pageKey(c.Doc, c.Page)
+		if mc, ok := merged[key]; ok {
+			ret = append(ret, mc)
+			delete(merged, key)
+		}
+	}
+	return ret
+}
+
+// mergeSeen merges the pages seen: for each document, the pages either
+// copy has seen since the later of the times they started it over, and
+// the pages of the picks in history since, less the pages of the undone
+// picks that no other pick has seen.
+func mergeSeen(m merger, history, undone []pick) map[string][]int {
+	restarted := func(st *state, key string) time.Time {
+		return latest(st.stamp(kindSeen, key), st.stamp(kindSeen, ""))
+	}
+
+	keys := make(map[string]bool)
+	for _, st := range []*state{m.newer, m.older} {
+		for key := range st.Seen {
+			keys[key] = true
+		}
+	}
+	for _, p := range history {
+		keys[p.Doc.key()] = true
+	}
+
+	var ret map[string][]int
+	add := func(key string, page int) {
+		if slices.Contains(ret[key], page) {
+			return
+		}
+		if ret == nil {
+			ret = make(map[string][]int)
+		}
+		ret[key] = append(ret[key], page)
+	}
+
+	for key := range keys {
+		since := latest(restarted(m.newer, key), restarted(m.older, key))
+		for _, st := range []*state{m.newer, m.older} {
+			if restarted(st, key).Before(since) {
+				// Seen before it started over.
+				continue
+			}
+			for _, page := range st.Seen[key] {
+				add(key, page)
+			}
+		}
+		for _, p := range undone {
+			if p.Doc.key() == key && !slices.ContainsFunc(history, func(q pick) bool { return q.Doc.key() == key && q.Page == p.Page && q.Time.After(since) }) {
+				ret[key] = slices.DeleteFunc(ret[key], func(page int) bool { return page == p.Page })
+			}
+		}
+		if len(ret[key]) == 0 {
+			delete(ret, key)
+		}
+		if since.IsZero() {
+			continue
+		}
+		for _, p := range history {
+			if p.Doc.key() == key && p.Time.After(since) {
+				add(key, p.Page)
+			}
+		}
+	}
+	return ret
+}
+
+// mergeHistory returns the picks in either history, oldest first, that
+// historyRetention keeps as of the latest.
+func mergeHistory(a, b []pick) []pick {
+	ret := slices.Clone(a)
+	known := make(map[string]int)
+	for i, p := range a {
+		known[p.id()] = i
+	}
+	for _, p := range b {
+		k := p.id()
+		i, ok := known[k]
+		if !ok {
+			known[k] = len(ret)
+			ret = append(ret, p)
+			continue
+		}
+		// The same pick, maybe read or timed in only one copy.
+		ret[i].Read = ret[i].Read || p.Read
+		ret[i].Viewed = max(ret[i].Viewed, p.Viewed)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		if !ret[i].Time.Equal(ret[j].Time) {
+			return ret[i].Time.Before(ret[j].Time)
+		}
+		return ret[i].Doc.key() < ret[j].Doc.key()
+	})
+	if n := len(ret); n > 0 {
+		ret = historyRetention.prune(ret, ret[n-1].Time)
+	}
+	return ret
+}
+
+// mergeNotes returns the notes in either list, oldest first.
+func mergeNotes(a, b []note) []note {
+	ret := slices.Clone(a)
+	for _, n := range b {
+		if !slices.ContainsFunc(ret, func(m note) bool { return m.Time.Equal(n.Time) && m.Text == n.Text }) {
+			ret = append(ret, n)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if !ret[i].Time.Equal(ret[j].Time) {
+			return ret[i].Time.Before(ret[j].Time)
+		}
+		return ret[i].Text < ret[j].Text
+	})
+	return ret
+}
+
+// mergeStamps returns the later stamp of each entry.
+func mergeStamps(a, b map[string]map[string]time.Time) map[string]map[string]time.Time {
+	var ret map[string]map[string]time.Time
+	for _, stamps := range []map[string]map[string]time.Time{a, b} {
+		for kind, keys := range stamps {
+			for key, t := range keys {
+				if ret == nil {
+					ret = make(map[string]map[string]time.Time)
+				}
+				if ret[kind] == nil {
+					ret[kind] = make(map[string]time.Time)
+				}
+				if t.After(ret[kind][key]) {
+					ret[kind][key] = t
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// latest returns the later of a and b.
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	t0 := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return t0.Add(time.Duration(minutes) * time.Minute) }
+	a := candidate{Path: "/docs/a.pdf"}
+	b := candidate{Path: "/docs/b.pdf"}
+	stamps := func(kind, key string, t time.Time) map[string]map[string]time.Time {
+		return map[string]map[string]time.Time{kind: {key: t}}
+	}
+
+	tests := []struct {
+		name string
+
+		// a and b make the two copies, fresh for each merge.
+		a, b func() *state
+
+		want func(*testing.T, *state)
+	}{
+		{
+			name: "histories add up",
+			a: func() *state {
+				return &state{Saved: at(10), History: []pick{{Doc: a, Page: 1, Time: at(1)}}}
+			},
+			b: func() *state {
+				return &state{Saved: at(20), History: []pick{{Doc: b, Page: 2, Time: at(2)}}}
+			},
+			want: func(t *testing.T, st *state) {
+				if len(st.History) != 2 || st.History[0].Doc.key() != a.key() || st.History[1].Doc.key() != b.key() {
+					t.Errorf("History = %v, want a's pick then b's", st.History)
+				}
+				if !st.Saved.Equal(at(20)) {
+					t.Errorf("Saved = %v, want %v", st.Saved, at(20))
+				}
+			},
+		},
+		{
+			name: "undone picks are dropped from History and Seen",
+			a: func() *state {
+				p := pick{Doc: a, Page: 3, Time: at(1)}
+				return &state{
+					Saved:   at(10),
+					Last:    &p,
+					History: []pick{{Doc: b, Page: 1, Time: at(0)}, p},
+					Seen:    map[string][]int{a.key(): {3}, b.key(): {1}},
+				}
+			},
+			b: func() *state {
+				p := pick{Doc: a, Page: 3, Time: at(1)}
+				return &state{
+					Saved:  at(20),
+					Seen:   map[string][]int{b.key(): {1}},
+					Stamps: stamps(kindUndone, p.id(), at(15)),
+				}
+			},
+			want: func(t *testing.T, st *state) {
+				if len(st.History) != 1 || st.History[0].Doc.key() != b.key() {
+					t.Errorf("History = %v, want only b's pick", st.History)
+				}
+				if st.Last == nil || st.Last.Doc.key() != b.key() {
+					t.Errorf("Last = %v, want b's pick", st.Last)
+				}
+				if _, ok := st.Seen[a.key()]; ok {
+					t.Errorf("Seen = %v, want none of a", st.Seen)
+				}
+				if !reflect.DeepEqual(st.Seen[b.key()], []int{1}) {
+					t.Errorf("Seen[b] = %v, want [1]", st.Seen[b.key()])
+				}
+			},
+		},
+		{
+			name: "a restart beats older pages seen in the other copy",
+			a: func() *state {
+				return &state{
+					Saved: at(10),
+					Seen:  map[string][]int{a.key(): {1, 2, 3}},
+				}
+			},
+			b: func() *state {
+				return &state{
+					Saved:   at(20),
+					History: []pick{{Doc: a, Page: 7, Time: at(6)}},
+					Seen:    map[string][]int{a.key(): {7}},
+					Stamps:  stamps(kindSeen, a.key(), at(5)),
+				}
+			},
+			want: func(t *testing.T, st *state) {
+				if !reflect.DeepEqual(st.Seen[a.key()], []int{7}) {
+					t.Errorf("Seen[a] = %v, want [7]", st.Seen[a.key()])
+				}
+			},
+		},
+		{
+			name: "a restart of every document beats older pages seen",
+			a: func() *state {
+				return &state{
+					Saved: at(10),
+					Seen:  map[string][]int{a.key(): {1}, b.key(): {2}},
+				}
+			},
+			b: func() *state {
+				return &state{Saved: at(20), Stamps: stamps(kindSeen, "", at(5))}
+			},
+			want: func(t *testing.T, st *state) {
+				if len(st.Seen) != 0 {
+					t.Errorf("Seen = %v, want none", st.Seen)
+				}
+			},
+		},
+		{
+			name: "pages seen in both copies since a restart are kept",
+			a: func() *state {
+				return &state{
+					Saved:  at(10),
+					Seen:   map[string][]int{a.key(): {4}},
+					Stamps: stamps(kindSeen, a.key(), at(5)),
+				}
+			},
+			b: func() *state {
+				return &state{
+					Saved:  at(20),
+					Seen:   map[string][]int{a.key(): {6}},
+					Stamps: stamps(kindSeen, a.key(), at(5)),
+				}
+			},
+			want: func(t *testing.T, st *state) {
+				if got := st.Seen[a.key()]; len(got) != 2 {
+					t.Errorf("Seen[a] = %v, want 4 and 6", got)
+				}
+			},
+		},
+		{
+			name: "a stamped deletion wins over an unstamped entry",
+			a: func() *state {
+				return &state{
+					Saved:  at(10),
+					Tags:   map[string][]string{a.key(): {"later"}},
+					Banned: map[string]bool{b.key(): true},
+				}
+			},
+			b: func() *state {
+				st := &state{Saved: at(20), Stamps: stamps(kindTags, a.key(), at(5))}
+				st.Stamps[kindBanned] = map[string]time.Time{b.key(): at(5)}
+				return st
+			},
+			want: func(t *testing.T, st *state) {
+				if len(st.Tags) != 0 {
+					t.Errorf("Tags = %v, want none", st.Tags)
+				}
+				if len(st.Banned) != 0 {
+					t.Errorf("Banned = %v, want none", st.Banned)
+				}
+			},
+		},
+		{
+			name: "the later stamp wins",
+			a: func() *state {
+				return &state{
+					Saved:   at(10),
+					Ratings: map[string]int{a.key(): 2},
+					Stamps:  stamps(kindRatings, a.key(), at(8)),
+				}
+			},
+			b: func() *state {
+				return &state{
+					Saved:   at(20),
+					Ratings: map[string]int{a.key(): 5},
+					Stamps:  stamps(kindRatings, a.key(), at(3)),
+				}
+			},
+			want: func(t *testing.T, st *state) {
+				if got := st.Ratings[a.key()]; got != 2 {
+					t.Errorf("Ratings[a] = %d, want 2", got)
+				}
+			},
+		},
+		{
+			name: "unstamped entries from both copies are kept",
+			a: func() *state {
+				return &state{Saved: at(10), Banned: map[string]bool{a.key(): true}}
+			},
+			b: func() *state {
+				return &state{Saved: at(20), Banned: map[string]bool{b.key(): true}}
+			},
+			want: func(t *testing.T, st *state) {
+				if !st.Banned[a.key()] || !st.Banned[b.key()] {
+					t.Errorf("Banned = %v, want both", st.Banned)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ab := tt.a()
+			ab.merge(tt.b())
+			tt.want(t, ab)
+
+			ba := tt.b()
+			ba.merge(tt.a())
+			if !reflect.DeepEqual(ab, ba) {
+				t.Errorf("merge isn't symmetric:\na.merge(b) = %+v\nb.merge(a) = %+v", ab, ba)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Terminal graphics protocols, for --term-graphics.
+const (
+	graphicsAuto  = "auto"
+	graphicsKitty = "kitty"
+	graphicsITerm = "iterm"
+	graphicsSixel = "sixel"
+)
+
+// termDPI is the resolution pages are drawn at for the terminal, where
+// the page is its size in pixels.
+const termDPI = 96
+
+// kittyChunk is the most base64 the kitty protocol takes in one escape.
+const kittyChunk = 4096
+
+// showInTerminal draws page of the document at path with r and writes it
+// to the terminal as an image.
+func showInTerminal(r renderer, path string, page int) error {
+	dir, err := os.MkdirTemp("", "randpage")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "page.png")
+	if err := r.render(path, page, termDPI, out); err != nil {
+		return err
+	}
+	buf, err := os.ReadFile(out)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	switch termGraphicsProtocol() {
+	case graphicsKitty:
+		writeKitty(w, buf)
+	case graphicsITerm:
+		fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a", len(buf), base64.StdEncoding.EncodeToString(buf))
+	default:
+		img, err := png.Decode(bytes.NewReader(buf))
+		if err != nil {
+			return err
+		}
+		writeSixel(w, img)
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// termGraphicsProtocol returns the --term-graphics protocol, or the one
+// the terminal looks to speak. iTerm2's is spoken by WezTerm too, and
+// $LC_TERMINAL makes it through ssh. Anything else gets sixel, which the
+// most terminals know.
+func termGraphicsProtocol() string {
+	if *termGraphics != graphicsAuto {
+		return *termGraphics
+	}
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "" || strings.Contains(os.Getenv("TERM"), "kitty") || os.Getenv("TERM_PROGRAM") == "ghostty":
+		return graphicsKitty
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app" || os.Getenv("LC_TERMINAL") == "iTerm2" || os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return graphicsITerm
+	}
+	return graphicsSixel
+}
+
+// writeKitty writes the png buf in kitty's graphics protocol, which
+// takes it in chunks.
+func writeKitty(w io.Writer, buf []byte) {
+	data := base64.StdEncoding.EncodeToString(buf)
+	for i := 0; i < len(data); i += kittyChunk {
+		more := 0
+		if i+kittyChunk < len(data) {
+			more = 1
+		}
+		chunk := data[i:min(i+kittyChunk, len(data))]
+		if i == 0 {
+			fmt.Fprintf(w, "\x1b_Ga=T,f=100,m=%d;%s\x1b\\", more, chunk)
+		} else {
+			fmt.Fprintf(w, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+}
+
+// writeSixel writes img as sixels, dithered to the web-safe colors. Each
+// band of six rows is drawn once for every color in it, as a row of
+// characters whose bits are the pixels of that color.
+func writeSixel(w io.Writer, img image.Image) {
+	b := img.Bounds()
+	p := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(p, p.Bounds(), img, b.Min)
+	width, height := p.Bounds().Dx(), p.Bounds().Dy()
+
+	fmt.Fprintf(w, "\x1bPq\"1;1;%d;%d", width, height)
+	for i, c := range p.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	for top := 0; top < height; top += 6 {
+		rows := make(map[uint8][]byte)
+		for y := top; y < min(top+6, height); y++ {
+			for x := 0; x < width; x++ {
+				c := p.ColorIndexAt(x, y)
+				row, ok := rows[c]
+				if !ok {
+					row = bytes.Repeat([]byte{'?'}, width)
+					rows[c] = row
+				}
+				row[x] += 1 << (y - top)
+			}
+		}
+
+		colors := make([]uint8, 0, len(rows))
+		for c := range rows {
+			colors = append(colors, c)
+		}
+		slices.Sort(colors)
+		for _, c := range colors {
+			fmt.Fprintf(w, "#%d", c)
+			writeSixelRow(w, rows[c])
+			io.WriteString(w, "$")
+		}
+		io.WriteString(w, "-")
+	}
+	io.WriteString(w, "\x1b\\")
+}
+
+// writeSixelRow writes row with runs of the same character compressed.
+func writeSixelRow(w io.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			w.Write(row[i:j])
+		}
+		i = j
+	}
+}
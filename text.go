@@ -0,0 +1,299 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// A texter is a format that can pull the text out of a page.
+type texter interface {
+	pageText(path string, page int) (string, error)
+}
+
+// pageText returns the text a pdf page shows, roughly: the strings its
+// content draws, with a line break wherever the text moves to a new line.
+// Pages in fonts with their own encodings come out as nothing, since
+// their codes aren't characters.
+func (pdfFormat) pageText(path string, page int) (string, error) {
+	doc, err := api.ReadContextFile(path)
+	if err != nil {
+		return "", err
+	}
+	d, _, _, err := doc.XRefTable.PageDict(page, false)
+	if err != nil {
+		return "", err
+	}
+	if d == nil {
+		return "", errors.New("no such page")
+	}
+	content, err := doc.XRefTable.PageContent(d)
+	if errors.Is(err, model.ErrNoContent) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	var pending []string
+	var nums []float64
+	newline := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+			b.WriteByte('\n')
+		}
+	}
+	for _, tok := range contentTokens(content) {
+		if f, err := strconv.ParseFloat(tok, 64); err == nil {
+			// Big gaps between the strings of a TJ are spaces.
+			if len(pending) > 0 && f < -200 {
+				pending = append(pending, " ")
+			}
+			nums = append(nums, f)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(tok, "("):
+			pending = append(pending, literalString(tok))
+			continue
+		case strings.HasPrefix(tok, "<"):
+			pending = append(pending, hexString(tok))
+			continue
+		case tok == "Tj" || tok == "TJ":
+			b.WriteString(strings.Join(pending, ""))
+		case tok == "'" || tok == `"`:
+			newline()
+			b.WriteString(strings.Join(pending, ""))
+		case tok == "Td" || tok == "TD":
+			// Moving along the line isn't a new one.
+			if len(nums) < 1 || nums[len(nums)-1] != 0 {
+				newline()
+			}
+		case tok == "T*" || tok == "Tm" || tok == "ET":
+			newline()
+		}
+		pending, nums = pending[:0], nums[:0]
+	}
+
+	text := strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, b.String()))
+	if !readable(text) {
+		return "", nil
+	}
+	return text, nil
+}
+
+// pageText returns the text of an epub spine item, with a line break
+// after each block of it, like a paragraph or heading.
+func (epubFormat) pageText(path string, page int) (string, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	spine, err := epubSpine(&zr.Reader)
+	if err != nil {
+		return "", err
+	}
+	if page < 1 || page > len(spine) {
+		return "", fmt.Errorf("epub has no spine item %d", page)
+	}
+	f, err := zr.Open(spine[page-1])
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	d := xml.NewDecoder(f)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var b strings.Builder
+	skip := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch strings.ToLower(t.Name.Local) {
+			case "head", "script", "style":
+				skip++
+			case "br":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch name := strings.ToLower(t.Name.Local); {
+			case name == "head" || name == "script" || name == "style":
+				skip--
+			case epubBlocks[name]:
+				b.WriteByte('\n')
+			}
+		case xml.CharData:
+			if skip == 0 {
+				// Line breaks in the source are spaces.
+				b.WriteString(strings.Map(func(r rune) rune {
+					if unicode.IsSpace(r) {
+						return ' '
+					}
+					return r
+				}, string(t)))
+			}
+		}
+	}
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// epubBlocks are the elements that end a line of an epub's text.
+var epubBlocks = map[string]bool{
+	"p": true, "div": true, "li": true, "tr": true, "blockquote": true, "pre": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"dt": true, "dd": true, "figcaption": true, "section": true,
+}
+
+// printText writes the text of pages first to last of doc, at path, to w
+// under a header saying where it's from.
+func printText(w io.Writer, t texter, doc candidate, path string, first, last int) error {
+	text, err := pagesText(t, path, first, last)
+	if err != nil {
+		return err
+	}
+
+	pages := fmt.Sprintf("p. %d", first)
+	if last > first {
+		pages = fmt.Sprintf("pp. %d-%d", first, last)
+	}
+	header := fmt.Sprintf("%s, %s", docTitle(doc), pages)
+	if len(doc.Authors) > 0 {
+		header = strings.Join(doc.Authors, ", ") + ", " + header
+	}
+	fmt.Fprintf(w, "== %s ==\n%s\n\n", header, doc)
+	if text == "" {
+		slog.Info("the page has no text", "path", doc, "page", first)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "%s\n\n", text)
+	return err
+}
+
+// pagesText returns the text of pages first to last of the document at
+// path, with a blank line between pages.
+func pagesText(t texter, path string, first, last int) (string, error) {
+	var texts []string
+	for page := first; page <= last; page++ {
+		text, err := t.pageText(path, page)
+		if err != nil {
+			return "", err
+		}
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n"), nil
+}
+
+// readable reports whether text looks like words, and not the codes of a
+// font with its own encoding: mostly letters and digits.
+func readable(text string) bool {
+	var words, other int
+	for _, r := range text {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			words++
+		case !unicode.IsSpace(r):
+			other++
+		}
+	}
+	return words > 2*other
+}
+
+// literalString decodes a pdf string token like "(a \(b\))".
+func literalString(tok string) string {
+	s := strings.TrimSuffix(strings.TrimPrefix(tok, "("), ")")
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r', 't', 'b', 'f':
+			b.WriteByte(' ')
+		case '\r', '\n':
+			// A line continuation.
+		default:
+			if c >= '0' && c <= '7' {
+				j := i
+				for j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7' {
+					j++
+				}
+				n, _ := strconv.ParseUint(s[i:j], 8, 8)
+				b.WriteByte(byte(n))
+				i = j - 1
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return latin1(b.String())
+}
+
+// hexString decodes a pdf string token like "<48656c6c6f>".
+func hexString(tok string) string {
+	s := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, strings.Trim(tok, "<>"))
+	if len(s)%2 == 1 {
+		s += "0"
+	}
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return ""
+	}
+	return latin1(string(buf))
+}
+
+// latin1 reads s's bytes as characters, which is close enough to the
+// standard pdf encodings for text in the Latin alphabet.
+func latin1(s string) string {
+	runes := make([]rune, len(s))
+	for i := 0; i < len(s); i++ {
+		runes[i] = rune(s[i])
+	}
+	return string(runes)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A nativeViewer is a document viewer randpage knows how to open to a
+// page, so the viewer setting can just name it.
+type nativeViewer struct {
+	name    string
+	args    []string // a template, as for --viewer
+	formats []format // the formats its pages are randpage's pages in
+
+	app    string   // the macOS app it is, for viewers run through osascript
+	places []string // where to look for it besides $PATH, under $VARIABLE directories
+}
+
+// The AppleScript run by osascript for Skim and Preview, which have no
+// arguments for a page. Preview has no scripting for one either, so it's
+// sent Go to Page's keystroke, which needs osascript to be allowed to
+// control the computer in the Accessibility settings.
+var (
+	skimScript = []string{
+		"on run argv",
+		`tell application "Skim"`,
+		"activate",
+		"set d to open POSIX file (item 1 of argv)",
+		"go d to page (item 2 of argv as integer) of d",
+		"end tell",
+		"end run",
+	}
+	previewScript = []string{
+		"on run argv",
+		`tell application "Preview"`,
+		"activate",
+		"open POSIX file (item 1 of argv)",
+		"end tell",
+		"delay 1",
+		`tell application "System Events" to tell process "Preview"`,
+		"keystroke \"g\" using {option down, command down}",
+		"keystroke (item 2 of argv)",
+		"key code 36",
+		"end tell",
+		"end run",
+	}
+)
+
+var nativeViewers = []nativeViewer{
+	{name: "zathura", args: []string{"zathura", "--page={page}", "{path}"}, formats: []format{pdfFormat{}, djvuFormat{}}},
+	{name: "evince", args: []string{"evince", "--page-index={page}", "{path}"}, formats: []format{pdfFormat{}, djvuFormat{}}},
+	{name: "okular", args: []string{"okular", "--page", "{page}", "{path}"}, formats: []format{pdfFormat{}, djvuFormat{}}},
+	{
+		name:    "sumatrapdf",
+		args:    []string{"SumatraPDF", "-page", "{page}", "{path}"},
+		formats: []format{pdfFormat{}, djvuFormat{}},
+		places:  []string{"$LOCALAPPDATA/SumatraPDF/SumatraPDF.exe", "$ProgramFiles/SumatraPDF/SumatraPDF.exe"},
+	},
+	{name: "mupdf", args: []string{"mupdf", "{path}", "{page}"}, formats: []format{pdfFormat{}}},
+	{name: "skim", args: osascript(skimScript), formats: []format{pdfFormat{}}, app: "Skim"},
+	{name: "preview", args: osascript(previewScript), formats: []format{pdfFormat{}}, app: "Preview"},
+}
+
+// osascript returns the template that runs script with the path and page
+// as its arguments.
+func osascript(script []string) []string {
+	args := []string{"osascript"}
+	for _, line := range script {
+		args = append(args, "-e", line)
+	}
+	return append(args, "{path}", "{page}")
+}
+
+// locate returns the program to run for v, and whether it's installed.
+func (v nativeViewer) locate() (string, bool) {
+	if v.app != "" {
+		if runtime.GOOS != "darwin" {
+			return "", false
+		}
+		for _, dir := range []string{"/Applications", "/System/Applications", expandHome("~/Applications")} {
+			if _, err := os.Stat(filepath.Join(dir, v.app+".app")); err == nil {
+				return v.args[0], true
+			}
+		}
+		return "", false
+	}
+
+	if p, err := exec.LookPath(v.args[0]); err == nil {
+		return p, true
+	}
+	for _, place := range v.places {
+		name, rest, _ := strings.Cut(strings.TrimPrefix(place, "$"), "/")
+		dir := os.Getenv(name)
+		if dir == "" {
+			continue
+		}
+		p := filepath.Join(dir, filepath.FromSlash(rest))
+		if _, err := os.Stat(p); err == nil {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// A viewerChoice is a viewer to try: the words of its template, and the
+// native viewer it is, if it's one.
+type viewerChoice struct {
+	words  []string
+	native *nativeViewer
+}
+
+// parseViewer parses a viewer setting: a template, or the name of a native
+// viewer or the path to its program.
+func parseViewer(s string) (viewerChoice, error) {
+	words, err := splitCommand(s)
+	if err != nil {
+		return viewerChoice{}, err
+	}
+	if len(words) > 1 {
+		return viewerChoice{words: words}, nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(filepath.Base(words[0])), ".exe")
+	for i, v := range nativeViewers {
+		if name != v.name {
+			continue
+		}
+		args := slices.Clone(v.args)
+		if strings.EqualFold(args[0], v.name) {
+			args[0] = words[0]
+			if p, ok := v.locate(); ok && strings.EqualFold(words[0], v.name) {
+				args[0] = p
+			}
+		}
+		return viewerChoice{words: args, native: &nativeViewers[i]}, nil
+	}
+	return viewerChoice{words: words}, nil
+}
+
+// viewerPriority is the config's viewers, in the order to try them.
+var viewerPriority []string
+
+// viewerChoices returns the viewers to try, in order: the --viewer or
+// config viewer, else the config's viewers, else the native viewers
+// installed here.
+func viewerChoices() ([]viewerChoice, error) {
+	settings := viewerPriority
+	if *viewerTemplate != "" {
+		settings = []string{*viewerTemplate}
+	}
+
+	var ret []viewerChoice
+	if settings == nil {
+		for i, v := range nativeViewers {
+			// Preview needs permission to be sent keystrokes, so it's
+			// only used when it's asked for.
+			if p, ok := v.locate(); ok && v.name != "preview" {
+				args := slices.Clone(v.args)
+				if v.app == "" {
+					args[0] = p
+				}
+				ret = append(ret, viewerChoice{words: args, native: &nativeViewers[i]})
+			}
+		}
+		return ret, nil
+	}
+
+	for _, s := range settings {
+		c, err := parseViewer(s)
+		if err != nil {
+			return nil, fmt.Errorf("viewer %q: %w", s, err)
+		}
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+// takesPaths reports whether c opens local files, rather than urls.
+func (c viewerChoice) takesPaths() bool {
+	return c.native != nil || slices.ContainsFunc(c.words, func(w string) bool {
+		return strings.Contains(w, "{path}")
+	})
+}
+
+// opens reports whether c opens local files in format f.
+func (c viewerChoice) opens(f format) bool {
+	if c.native != nil {
+		return slices.Contains(c.native.formats, f)
+	}
+	return c.takesPaths()
+}
+
+// installed reports whether c's program is there to run.
+func (c viewerChoice) installed() bool {
+	if c.native != nil && c.native.app != "" {
+		_, ok := c.native.locate()
+		return ok
+	}
+	_, err := exec.LookPath(c.words[0])
+	return err == nil
+}
+
+// openDirect opens the local file at path, in format f, to page with the
+// first of the viewers that takes paths and doesn't fail, waiting for it
+// to exit. It reports whether one did, and otherwise the document should
+// be served to a browser.
+func openDirect(f format, path string, page int) (bool, error) {
+	choices, err := viewerChoices()
+	if err != nil {
+		return false, err
+	}
+	for _, c := range choices {
+		if !c.opens(f) {
+			continue
+		}
+		if !c.installed() {
+			slog.Info("viewer not found", "viewer", c.words[0])
+			continue
+		}
+		args := fillTemplate(c.words, fileURL(path)+"#page="+strconv.Itoa(page), path, page)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			slog.Error("executing viewer", "viewer", c.words[0], "path", path, "err", err)
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// urlViewers returns the commands to try, in order, to point a viewer at
+// a document's url, and whether to leave each running instead of waiting
+// for it: the viewers that take urls, then the platform's default, then
+// any browsers installed.
+func urlViewers(url string, page int) ([][]string, []bool, error) {
+	choices, err := viewerChoices()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var cmds [][]string
+	var detach []bool
+	for _, c := range choices {
+		if c.takesPaths() {
+			continue
+		}
+		if !c.installed() {
+			slog.Info("viewer not found", "viewer", c.words[0])
+			continue
+		}
+		cmds = append(cmds, fillTemplate(c.words, url, "", page))
+		detach = append(detach, false)
+	}
+
+	args, d := defaultViewer()
+	cmds = append(cmds, append(args, url))
+	detach = append(detach, d)
+	for _, b := range browsers() {
+		if _, err := exec.LookPath(b); err == nil {
+			cmds = append(cmds, []string{b, url})
+			detach = append(detach, true)
+		}
+	}
+	return cmds, detach, nil
+}
+
+// fillTemplate fills in the placeholders of the viewer template words.
+func fillTemplate(words []string, url, path string, page int) []string {
+	r := strings.NewReplacer("{url}", url, "{path}", path, "{page}", strconv.Itoa(page))
+	located := false
+	var args []string
+	for _, w := range words {
+		located = located || strings.Contains(w, "{url}") || strings.Contains(w, "{path}")
+		args = append(args, r.Replace(w))
+	}
+	if !located {
+		args = append(args, url)
+	}
+	return args
+}
+
+// defaultViewer returns the command that opens urls on this platform, and
+// whether to leave it running instead of waiting for it: xdg-open may
+// start a browser and only return when the browser quits.
+func defaultViewer() ([]string, bool) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"open"}, false
+	case "windows":
+		// Like start, without a shell to quote the url for.
+		return []string{"rundll32", "url.dll,FileProtocolHandler"}, true
+	default:
+		return []string{"xdg-open"}, true
+	}
+}
+
+// browsers returns the browsers to try when the default viewer fails,
+// where there's no one way to open urls.
+func browsers() []string {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return nil
+	default:
+		return []string{"sensible-browser", "firefox", "chromium", "google-chrome"}
+	}
+}
+
+// viewerError explains a viewer that couldn't be run.
+func viewerError(err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w (set viewer in the config to the command that opens urls)", err)
+	}
+	return err
+}
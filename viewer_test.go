@@ -0,0 +1,27 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFillTemplate(t *testing.T) {
+	const url, path = "http://127.0.0.1:8000/a%20b.pdf#page=3", "/docs/a b.pdf"
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{"open"}, []string{"open", url}},
+		{[]string{"zathura", "--page={page}", "{path}"}, []string{"zathura", "--page=3", path}},
+		{[]string{"firefox", "{url}"}, []string{"firefox", url}},
+		{[]string{"sh", "-c", "view {path} {page}"}, []string{"sh", "-c", "view " + path + " 3"}},
+		{[]string{"evince", "-i", "{page}"}, []string{"evince", "-i", "3", url}},
+	}
+
+	for _, tt := range tests {
+		if got := fillTemplate(tt.words, url, path, 3); !slices.Equal(got, tt.want) {
+			t.Errorf("fillTemplate(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
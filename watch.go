@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long the watched roots have to be quiet before
+// they're walked again, so copying in a folder of papers is one update
+// rather than hundreds.
+const watchSettle = 2 * time.Second
+
+// watch keeps the indexes of the local directories in roots up to date as
+// documents are added, moved, and deleted, until it's killed. Each change
+// is handled by walking the root again: the index means only the
+// directories that changed are read.
+func watch(w *walker, roots []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	// dirRoot maps each watched directory to the root it's under.
+	dirRoot := make(map[string]string)
+
+	update := func(root string) {
+		docs := w.walk(root)
+
+		// The index lists every directory the walk went through.
+		for dir := range loadIndex(w, root, loadIgnores(root), false).old {
+			if _, ok := dirRoot[dir]; ok {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				slog.Info("watching directory", "path", dir, "err", err)
+				continue
+			}
+			dirRoot[dir] = root
+		}
+
+		slog.Info("updated index", "root", root, "count", len(docs))
+	}
+
+	watched := 0
+	for _, root := range roots {
+		if info, err := os.Stat(root); err != nil || !info.IsDir() || schemeOf(root) != "" {
+			slog.Info("not watching", "root", root)
+			continue
+		}
+		update(root)
+		watched++
+	}
+	if watched == 0 {
+		slog.Info("nothing to watch")
+		return nil
+	}
+
+	dirty := make(map[string]bool)
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			dir := filepath.Dir(event.Name)
+			root, ok := dirRoot[dir]
+			if !ok {
+				continue
+			}
+			if event.Op&fsnotify.Remove != 0 || event.Op&fsnotify.Rename != 0 {
+				delete(dirRoot, event.Name)
+			}
+
+			// A file written in place doesn't change its directory's
+			// modification time, so the directory's index entry can't be
+			// trusted.
+			if w.stale == nil {
+				w.stale = make(map[string]bool)
+			}
+			w.stale[dir] = true
+			dirty[root] = true
+			settle.Reset(watchSettle)
+
+		case <-settle.C:
+			for root := range dirty {
+				update(root)
+			}
+			dirty = make(map[string]bool)
+			w.stale = nil
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Info("watching", "err", err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// webdavSource lists and fetches documents on a WebDAV share, like
+// Nextcloud's. webdavs:// urls use https, webdav:// plain http. The
+// username and password come from RANDPAGE_WEBDAV_USER and
+// RANDPAGE_WEBDAV_PASSWORD, or the config file.
+type webdavSource struct {
+	u *url.URL
+	w *walker
+}
+
+func newWebDAVSource(arg string, w *walker) (source, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	return webdavSource{u: u, w: w}, nil
+}
+
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Prop struct {
+				Collection *struct{} `xml:"DAV: resourcetype>collection"`
+				Length     int64     `xml:"DAV: getcontentlength"`
+				Modified   string    `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+const davPropfind = `<?xml version="1.0"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// httpURL returns the http or https url for a webdav or webdavs one.
+func (s webdavSource) httpURL(u *url.URL) *url.URL {
+	ret := *u
+	ret.Scheme = strings.Replace(u.Scheme, "webdav", "http", 1)
+	return &ret
+}
+
+func (s webdavSource) request(method string, u *url.URL, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.httpURL(u).String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if user := envOr("RANDPAGE_WEBDAV_USER", cfg.WebDAV.User); user != "" {
+		req.SetBasicAuth(user, envOr("RANDPAGE_WEBDAV_PASSWORD", cfg.WebDAV.Password))
+	}
+	if method == "PROPFIND" {
+		req.Header.Set("Depth", "1")
+		req.Header.Set("Content-Type", "application/xml")
+	}
+
+	return doHTTP(req)
+}
+
+func (s webdavSource) list() ([]candidate, error) {
+	root := *s.u
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+
+	var ret []candidate
+
+	// Servers often refuse "Depth: infinity", so walk one collection at a
+	// time.
+	var visit func(dir *url.URL, depth int) error
+	visit = func(dir *url.URL, depth int) error {
+		resp, err := s.request("PROPFIND", dir, strings.NewReader(davPropfind))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		var ms davMultistatus
+		if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+			return err
+		}
+
+		for _, r := range ms.Responses {
+			href, err := url.Parse(r.Href)
+			if err != nil {
+				continue
+			}
+			entry := dir.ResolveReference(href)
+			entry.Scheme = dir.Scheme
+
+			isDir := false
+			var size int64
+			var modTime time.Time
+			for _, ps := range r.Propstat {
+				if ps.Prop.Collection != nil {
+					isDir = true
+				}
+				size = max(size, ps.Prop.Length)
+				if t, err := http.ParseTime(ps.Prop.Modified); err == nil {
+					modTime = t
+				}
+			}
+
+			p := strings.TrimSuffix(entry.Path, "/")
+			if p == strings.TrimSuffix(dir.Path, "/") || s.w.skipRemote(root.Path, p, isDir) {
+				continue
+			}
+
+			if isDir {
+				if s.w.maxDepth == 0 || depth+1 < s.w.maxDepth {
+					entry.Path = p + "/"
+					if err := visit(entry, depth+1); err != nil {
+						slog.Info("listing webdav collection", "url", entry, "err", err)
+					}
+				}
+			} else if looksLikeDocument(path.Base(p)) {
+				ret = append(ret, candidate{Path: entry.String(), Size: size, ModTime: modTime})
+			}
+		}
+
+		return nil
+	}
+
+	if err := visit(&root, 0); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s webdavSource) fetch(c candidate, w io.Writer) error {
+	u, err := url.Parse(c.Path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.request("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// zoteroSource lists the files attached to items in a local Zotero
+// library, with each parent item's title and authors. zotero: alone uses
+// the default data directory (~/Zotero); zotero:/some/dir names another.
+type zoteroSource struct {
+	dir string
+	w   *walker
+}
+
+func newZoteroSource(arg string, w *walker) (source, error) {
+	dir := strings.TrimPrefix(arg, "zotero:")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, "Zotero")
+	}
+	return zoteroSource{dir: dir, w: w}, nil
+}
+
+// zoteroAttachments selects each attachment's key, path, title, and
+// authors. Imported files have paths like "storage:paper.pdf", relative to
+// storage/<key>; linked files have absolute paths. The title and authors
+// are the parent item's, if it has one.
+const zoteroAttachments = `
+SELECT
+	attach.key,
+	ia.path,
+	coalesce(
+		(SELECT v.value FROM itemData d
+			JOIN fields f ON f.fieldID = d.fieldID
+			JOIN itemDataValues v ON v.valueID = d.valueID
+			WHERE d.itemID = coalesce(ia.parentItemID, ia.itemID) AND f.fieldName = 'title'),
+		''),
+	coalesce(
+		(SELECT group_concat(name, '; ') FROM (
+			SELECT trim(c.firstName || ' ' || c.lastName) AS name FROM itemCreators ic
+				JOIN creators c ON c.creatorID = ic.creatorID
+				WHERE ic.itemID = coalesce(ia.parentItemID, ia.itemID)
+				ORDER BY ic.orderIndex)),
+		'')
+FROM itemAttachments ia
+JOIN items attach ON attach.itemID = ia.itemID
+WHERE ia.path IS NOT NULL
+	AND ia.itemID NOT IN (SELECT itemID FROM deletedItems)
+`
+
+func (s zoteroSource) list() ([]candidate, error) {
+	db, err := openLibraryDB(filepath.Join(s.dir, "zotero.sqlite"))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(zoteroAttachments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ret []candidate
+	for rows.Next() {
+		var key, path, title, authors string
+		if err := rows.Scan(&key, &path, &title, &authors); err != nil {
+			return nil, err
+		}
+
+		if name, ok := strings.CutPrefix(path, "storage:"); ok {
+			path = filepath.Join(s.dir, "storage", key, name)
+		} else if !filepath.IsAbs(path) {
+			// Paths relative to a linked attachment base directory
+			// ("attachments:...") need Zotero's preferences to resolve.
+			continue
+		}
+
+		if !looksLikeDocument(path) || s.w.excluded(s.dir, path, false) {
+			continue
+		}
+
+		c := candidate{Path: path, Title: title}
+		if authors != "" {
+			c.Authors = strings.Split(authors, "; ")
+		}
+		ret = append(ret, c)
+	}
+
+	return ret, rows.Err()
+}
+
+func (s zoteroSource) fetch(c candidate, w io.Writer) error {
+	return dirSource{}.fetch(c, w)
+}